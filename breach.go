@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+// paddingHeader carries the random padding of compressed responses.
+const paddingHeader = "X-Padding"
+
+type contextSensitiveT struct{}
+
+var contextSensitive contextSensitiveT
+
+// CompressPadding adds a header with a random amount, between 1 and max bytes,
+// of random padding to every compressed response. This mitigates BREACH style
+// attacks, which guess secrets in a page from the changes in the size of the
+// compressed response, by making the size noisy. The padding is sent as the
+// X-Padding header.
+func CompressPadding(max int) CompressOption {
+	return func(o *compressOptions) {
+		o.padding = max
+	}
+}
+
+// MarkSensitive marks the response to the request with the given context as
+// containing secrets, like CSRF tokens, so CompressHandler sends it uncompressed.
+// This has to happen before the handler writes the body, it has no effect for
+// requests that aren't served through CompressHandler.
+func MarkSensitive(ctx context.Context) {
+	if sensitive, ok := ctx.Value(contextSensitive).(*atomic.Bool); ok {
+		sensitive.Store(true)
+	}
+}
+
+// withSensitiveMarker returns a shallow copy of r with a context that allows the
+// handler to call MarkSensitive.
+func withSensitiveMarker(r *http.Request) (*http.Request, *atomic.Bool) {
+	sensitive := new(atomic.Bool)
+	return r.WithContext(context.WithValue(r.Context(), contextSensitive, sensitive)), sensitive
+}
+
+// padding returns between 1 and max bytes of random hex encoded padding.
+func padding(max int) string {
+	n := rand.N(max) + 1
+	b := make([]byte, (n+1)/2)
+	for i := range b {
+		b[i] = byte(rand.Uint32())
+	}
+	return hex.EncodeToString(b)[:n]
+}
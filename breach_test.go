@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressPadding(t *testing.T) {
+	h := CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("Gorilla!\n", 100))
+	}), CompressPadding(32))
+
+	lengths := make(map[int]bool)
+	for range 50 {
+		w := serveCompressed(h, "gzip")
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		pad := w.Header().Get(paddingHeader)
+		assert.NotEmpty(t, pad)
+		assert.LessOrEqual(t, len(pad), 32)
+		lengths[len(pad)] = true
+	}
+	assert.Greater(t, len(lengths), 1)
+
+	w := serveCompressed(h, "")
+	assert.Empty(t, w.Header().Get(paddingHeader))
+}
+
+func TestMarkSensitive(t *testing.T) {
+	h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("secret") {
+			MarkSensitive(r.Context())
+		}
+		_, _ = io.WriteString(w, strings.Repeat("Gorilla!\n", 100))
+	}))
+
+	w := serveCompressed(h, "gzip")
+	assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+
+	r := httptest.NewRequest("GET", "/?secret", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 100), w.Body.String())
+
+	// no-op outside the compress handler
+	MarkSensitive(r.Context())
+}
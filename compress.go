@@ -2,15 +2,21 @@ package middlewares
 
 import (
 	"io"
-	"log/slog"
+	"mime"
 	"net/http"
+	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/casualjim/middlewares/slogx"
 	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/felixge/httpsnoop"
 )
@@ -18,15 +24,42 @@ import (
 const (
 	deflate = "deflate"
 	gzipa   = "gzip"
+	zstda   = "zstd"
+	br      = "br"
 )
 
+// compressor is the common interface of the pooled gzip, flate, zstd and
+// brotli writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressorPool returns the writer pool for the given content-coding and gzip
+// compression level, or nil when the coding isn't supported.
+func compressorPool(encoding string, level int) *sync.Pool {
+	switch encoding {
+	case gzipa:
+		return gzipWriterPools[gzipPoolIndex(level)]
+	case deflate:
+		return flateWriterPools[flatePoolIndex(level)]
+	case zstda:
+		return zstdWriterPools[zstdPoolIndex(level)]
+	case br:
+		return brotliWriterPools[brotliPoolIndex(level)]
+	default:
+		return nil
+	}
+}
+
 // gzipWriterPools stores a sync.Pool for each compression level for reuse of
 // gzip.Writers. Use poolIndex to covert a compression level to an index into
 // gzipWriterPools.
-var gzipWriterPools [gzip.BestCompression - gzip.BestSpeed + 2]*sync.Pool
+var gzipWriterPools [gzip.BestCompression - gzip.NoCompression + 2]*sync.Pool
 
 func init() {
-	for i := gzip.BestSpeed; i <= gzip.BestCompression; i++ {
+	for i := gzip.NoCompression; i <= gzip.BestCompression; i++ {
 		addGzipLevelPool(i)
 	}
 	addGzipLevelPool(gzip.DefaultCompression)
@@ -37,9 +70,9 @@ func init() {
 func gzipPoolIndex(level int) int {
 	// gzip.DefaultCompression == -1, so we need to treat it special.
 	if level == gzip.DefaultCompression {
-		return gzip.BestCompression - gzip.BestSpeed + 1
+		return gzip.BestCompression - gzip.NoCompression + 1
 	}
-	return level - gzip.BestSpeed
+	return level - gzip.NoCompression
 }
 
 func addGzipLevelPool(level int) {
@@ -57,10 +90,10 @@ func addGzipLevelPool(level int) {
 // flateWriterPools stores a sync.Pool for each compression level for reuse of
 // gzip.Writers. Use poolIndex to covert a compression level to an index into
 // flateWriterPools.
-var flateWriterPools [flate.BestCompression - flate.BestSpeed + 2]*sync.Pool
+var flateWriterPools [flate.BestCompression - flate.NoCompression + 2]*sync.Pool
 
 func init() {
-	for i := flate.BestSpeed; i <= flate.BestCompression; i++ {
+	for i := flate.NoCompression; i <= flate.BestCompression; i++ {
 		addFlateLevelPool(i)
 	}
 	addFlateLevelPool(flate.DefaultCompression)
@@ -71,9 +104,9 @@ func init() {
 func flatePoolIndex(level int) int {
 	// flate.DefaultCompression == -1, so we need to treat it special.
 	if level == flate.DefaultCompression {
-		return flate.BestCompression - flate.BestSpeed + 1
+		return flate.BestCompression - flate.NoCompression + 1
 	}
-	return level - flate.BestSpeed
+	return level - flate.NoCompression
 }
 
 func addFlateLevelPool(level int) {
@@ -88,155 +121,659 @@ func addFlateLevelPool(level int) {
 	}
 }
 
+// zstdWriterPools stores a sync.Pool for each zstd encoder level for reuse of
+// zstd.Encoders. Use zstdPoolIndex to convert a gzip compression level to an
+// index into zstdWriterPools.
+var zstdWriterPools [zstd.SpeedBestCompression - zstd.SpeedFastest + 1]*sync.Pool
+
+func init() {
+	for l := zstd.SpeedFastest; l <= zstd.SpeedBestCompression; l++ {
+		addZstdLevelPool(l)
+	}
+}
+
+// zstdPoolIndex maps a gzip compression level to the index of the closest zstd
+// encoder level in zstdWriterPools.
+func zstdPoolIndex(level int) int {
+	l := zstd.EncoderLevelFromZstd(level)
+	switch level {
+	case gzip.DefaultCompression:
+		l = zstd.SpeedDefault
+	case gzip.BestCompression:
+		l = zstd.SpeedBestCompression
+	}
+	return int(l - zstd.SpeedFastest)
+}
+
+func addZstdLevelPool(level zstd.EncoderLevel) {
+	zstdWriterPools[level-zstd.SpeedFastest] = &sync.Pool{
+		New: func() interface{} {
+			// NewWriter only returns error on invalid options, the encoder level is
+			// one of the predefined ones so it is okay to ignore the returned error.
+			// Every response gets its own encoder, so there is no point in letting
+			// it spin up extra goroutines.
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+			return w
+		},
+	}
+}
+
+// brotliWriterPools stores a sync.Pool for each compression level for reuse of
+// brotli.Writers. Use brotliPoolIndex to convert a gzip compression level to an
+// index into brotliWriterPools.
+var brotliWriterPools [brotli.BestCompression - brotli.BestSpeed + 1]*sync.Pool
+
+func init() {
+	for i := brotli.BestSpeed; i <= brotli.BestCompression; i++ {
+		addBrotliLevelPool(i)
+	}
+}
+
+// brotliPoolIndex maps a gzip compression level to its index into
+// brotliWriterPools. The gzip levels map onto the brotli levels of the same
+// number, so the slowest brotli levels are never used.
+func brotliPoolIndex(level int) int {
+	if level == gzip.DefaultCompression {
+		return brotli.DefaultCompression - brotli.BestSpeed
+	}
+	return level - brotli.BestSpeed
+}
+
+func addBrotliLevelPool(level int) {
+	brotliWriterPools[level-brotli.BestSpeed] = &sync.Pool{
+		New: func() interface{} {
+			return brotli.NewWriterLevel(nil, level)
+		},
+	}
+}
+
+// defaultCompressEncodings lists the content-codings CompressHandler offers, in
+// order of preference.
+var defaultCompressEncodings = []string{zstda, br, gzipa, deflate}
+
+// defaultSkipCompressContentTypes lists media types that are already compressed,
+// so compressing them again only burns CPU.
+var defaultSkipCompressContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+// CompressOption configures the handler created by CompressHandlerWithOptions.
+type CompressOption func(*compressOptions)
+
+type compressOptions struct {
+	level     int
+	encodings []string
+	minSize   int
+	types     []string
+	skipTypes []string
+	skip      func(*http.Request) bool
+
+	skipStreams bool
+	weakETags   bool
+
+	observe func(*http.Request, CompressStats)
+	padding int
+}
+
+// CompressLevel sets the compression level, see CompressHandlerLevel for the
+// valid values.
+func CompressLevel(level int) CompressOption {
+	return func(o *compressOptions) {
+		o.level = level
+	}
+}
+
+// CompressEncodings sets the content-codings that can be used, in the order the
+// server prefers them. Unsupported encodings are ignored.
+func CompressEncodings(encodings ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.encodings = encodings
+	}
+}
+
+// CompressMinSize sets the minimum size of a response body before it gets
+// compressed. The response is buffered until it reaches that size, smaller
+// responses are sent as is.
+func CompressMinSize(size int) CompressOption {
+	return func(o *compressOptions) {
+		o.minSize = size
+	}
+}
+
+// CompressContentTypes restricts compression to responses with a media type that
+// matches one of the patterns. Patterns use path.Match syntax, so "text/*" and
+// "application/*+json" match entire families of media types.
+func CompressContentTypes(patterns ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.types = append(o.types, lowerAll(patterns)...)
+	}
+}
+
+// SkipCompressContentTypes prevents compression of responses with a media type
+// that matches one of the patterns, in addition to the already compressed media
+// types that are skipped by default. Patterns use path.Match syntax.
+func SkipCompressContentTypes(patterns ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.skipTypes = append(o.skipTypes, lowerAll(patterns)...)
+	}
+}
+
+// SkipCompress prevents compression of the response when skip returns true for
+// the request.
+func SkipCompress(skip func(*http.Request) bool) CompressOption {
+	return func(o *compressOptions) {
+		o.skip = skip
+	}
+}
+
+// SkipCompressStreams sends streaming responses, like server-sent events or
+// responses with chunked transfer encoding, as is.
+func SkipCompressStreams() CompressOption {
+	return func(o *compressOptions) {
+		o.skipStreams = true
+	}
+}
+
+// CompressWeakETags marks the ETag of compressed responses as weak, instead of
+// suffixing strong ETags with the content-coding.
+func CompressWeakETags() CompressOption {
+	return func(o *compressOptions) {
+		o.weakETags = true
+	}
+}
+
+// newCompressOptions applies the options to the defaults and normalizes the
+// result, only supported encodings remain.
+func newCompressOptions(opts []CompressOption) *compressOptions {
+	o := &compressOptions{
+		level:     gzip.DefaultCompression,
+		encodings: defaultCompressEncodings,
+		skipTypes: slices.Clone(defaultSkipCompressContentTypes),
+	}
+	for opt := range slices.Values(opts) {
+		opt(o)
+	}
+
+	if o.level < gzip.DefaultCompression || o.level > gzip.BestCompression {
+		o.level = gzip.DefaultCompression
+	}
+	offers := make([]string, 0, len(o.encodings))
+	for enc := range slices.Values(lowerAll(o.encodings)) {
+		if compressorPool(enc, o.level) != nil && !slices.Contains(offers, enc) {
+			offers = append(offers, enc)
+		}
+	}
+	o.encodings = offers
+	return o
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for v := range slices.Values(values) {
+		lowered = append(lowered, strings.ToLower(strings.TrimSpace(v)))
+	}
+	return lowered
+}
+
 // Adapted from http://github.com/gorilla/handlers
 // Their middleware is greedy when it comes to implementing response writer methods
 // this version uses httpsnoop to avoid expanding the interface and potentially break libraries
 // that make assumptions based on those implementations
 
-// CompressHandler gzip compresses HTTP responses for clients that support it
-// via the 'Accept-Encoding' header.
+// CompressHandler compresses HTTP responses with zstd, brotli, gzip or deflate
+// for clients that support it via the 'Accept-Encoding' header.
 //
 // Compressing TLS traffic may leak the page contents to an attacker if the
 // page contains user input: http://security.stackexchange.com/a/102015/12208
+// Handlers can call MarkSensitive for responses that contain secrets, and the
+// CompressPadding option makes the size of compressed responses noisy.
 func CompressHandler(h http.Handler) http.Handler {
-	return CompressHandlerLevel(h, gzip.DefaultCompression)
+	return CompressHandlerWithOptions(h)
 }
 
-// CompressHandlerLevel gzip compresses HTTP responses with specified compression level
+// CompressHandlerLevel compresses HTTP responses with specified compression level
 // for clients that support it via the 'Accept-Encoding' header.
 //
 // The compression level should be gzip.DefaultCompression, gzip.NoCompression,
 // or any integer value between gzip.BestSpeed and gzip.BestCompression inclusive.
 // gzip.DefaultCompression is used in case of invalid compression level.
+// The level is mapped onto the closest equivalent for zstd and brotli.
 func CompressHandlerLevel(h http.Handler, level int) http.Handler {
-	if level < gzip.DefaultCompression || level > gzip.BestCompression {
-		level = gzip.DefaultCompression
-	}
+	return CompressHandlerWithOptions(h, CompressLevel(level))
+}
+
+// CompressHandlerEncodings compresses HTTP responses with the specified
+// compression level, using the encodings in the order the server prefers them.
+func CompressHandlerEncodings(h http.Handler, level int, encodings ...string) http.Handler {
+	return CompressHandlerWithOptions(h, CompressLevel(level), CompressEncodings(encodings...))
+}
+
+// CompressHandlerWithOptions compresses HTTP responses for clients that support
+// it via the 'Accept-Encoding' header.
+//
+// The encoding is negotiated with the q-values of the 'Accept-Encoding' header,
+// ties are broken by the server's order of preference. When the client refuses
+// every encoding including identity, the handler responds with 406 Not
+// Acceptable.
+//
+// Whether a response gets compressed is decided when the handler writes the
+// first bytes of the body, or more when a minimum size is configured. Responses
+// that already have a Content-Encoding or have a media type that is skipped are
+// sent as is.
+//
+// Streaming responses are compressed too. Flushing the response writer flushes
+// the compressed data to the client, so server-sent events and long polling keep
+// working. A flush also settles the decision to compress, regardless of the
+// minimum size.
+//
+// A compressed response is a different representation than the uncompressed
+// one, so a strong ETag gets the content-coding appended, "abc" becomes
+// "abc-gzip", and the suffix of the negotiated encoding is stripped again from
+// the If-Match and If-None-Match headers of requests. A 304 only gets the
+// suffixed ETag when the client sent it. Compressed responses don't advertise
+// Accept-Ranges. 204, 206 and 304 responses are never compressed. A HEAD
+// request gets the headers a GET would, judging the size of the body by the
+// Content-Length of the response.
+func CompressHandlerWithOptions(h http.Handler, opts ...CompressOption) http.Handler {
+	o := newCompressOptions(opts)
+	offers := o.encodings
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	L:
-		for enc := range slices.Values(strings.Split(r.Header.Get("Accept-Encoding"), ",")) {
-			switch strings.TrimSpace(enc) {
-			case gzipa:
-
-				w.Header().Set("Content-Encoding", gzipa)
-				w.Header().Add("Vary", "Accept-Encoding")
-
-				index := gzipPoolIndex(level)
-				gzw := gzipWriterPools[index].Get().(*gzip.Writer)
-				gzw.Reset(w)
-				defer func() {
-					if err := gzw.Close(); err != nil {
-						slog.Error("closing gzip writer", slogx.Error(err))
+		if o.skip != nil && o.skip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, ok := negotiateEncoding(r.Header.Values("Accept-Encoding"), offers)
+		if !ok {
+			JSONError(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
+		if encoding == identity {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var held []string
+		if o.weakETags {
+			held = weakETags(r)
+		} else {
+			r, held = stripETagSuffixes(r, encoding)
+		}
+		r, sensitive := withSensitiveMarker(r)
+
+		cw := &compressWriter{
+			rw:        w,
+			req:       r,
+			opts:      o,
+			encoding:  encoding,
+			sensitive: sensitive,
+			held:      held,
+			head:      r.Method == http.MethodHead,
+		}
+
+		h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return cw.WriteHeader
+			},
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return cw.Write
+			},
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					return io.Copy(cw, src)
+				}
+			},
+			Flush: func(flush httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					if err := cw.Flush(); err != nil {
+						requestLogger(r.Context(), nil).Error("flushing "+encoding+" writer", slogx.Error(err))
+						return
+					}
+					flush()
+				}
+			},
+			Push: func(push httpsnoop.PushFunc) httpsnoop.PushFunc {
+				return func(target string, opts *http.PushOptions) error {
+					if opts == nil {
+						opts = &http.PushOptions{}
 					}
-					gzipWriterPools[index].Put(gzw)
-				}()
-
-				rrw := w
-				var isStream bool
-				w = httpsnoop.Wrap(w, httpsnoop.Hooks{
-					WriteHeader: func(headerFunc httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-						return func(code int) {
-							w.Header().Del("Content-Length")
-							headerFunc(code)
-						}
-					},
-					Write: func(_ httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-						return func(b []byte) (i int, e error) {
-							h := w.Header()
-							if h.Get("Content-Type") == "" {
-								h.Set("Content-Type", http.DetectContentType(b))
-							}
-
-							if isStream || strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") || w.Header().Get("Transfer-Encoding") == "chunked" {
-								isStream = true
-								gzw.Reset(io.Discard)
-								rrw.Header().Del("Content-Encoding")
-								rrw.Header().Del("Vary")
-								return rrw.Write(b)
-							} else {
-								h.Del("Content-Length")
-							}
-							return gzw.Write(b)
-						}
-					},
-				})
-
-				break L
-			case deflate:
-				w.Header().Set("Content-Encoding", deflate)
-				w.Header().Add("Vary", "Accept-Encoding")
-
-				index := flatePoolIndex(level)
-				fw := flateWriterPools[index].Get().(*flate.Writer)
-				fw.Reset(w)
-				defer func() {
-					if err := fw.Close(); err != nil {
-						slog.Error("closing flate writer", slogx.Error(err))
+					if opts.Header == nil {
+						opts.Header = http.Header{}
 					}
-					flateWriterPools[index].Put(fw)
-				}()
-
-				rrw := w
-				var isStream bool
-				w = httpsnoop.Wrap(w, httpsnoop.Hooks{
-					WriteHeader: func(headerFunc httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-						return func(code int) {
-							w.Header().Del("Content-Length")
-							headerFunc(code)
-						}
-					},
-					Write: func(_ httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-						return func(b []byte) (i int, e error) {
-							h := w.Header()
-							if h.Get("Content-Type") == "" {
-								h.Set("Content-Type", http.DetectContentType(b))
-							}
-
-							if isStream || strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") || w.Header().Get("Transfer-Encoding") == "chunked" {
-								isStream = true
-								fw.Reset(io.Discard)
-								rrw.Header().Del("Content-Encoding")
-								rrw.Header().Del("Vary")
-								return rrw.Write(b)
-							} else {
-								h.Del("Content-Length")
-							}
-							return fw.Write(b)
-						}
-					},
-					Push: func(httpsnoop.PushFunc) httpsnoop.PushFunc {
-						return func(_ string, opts *http.PushOptions) error {
-							if opts == nil {
-								opts = &http.PushOptions{
-									Header: http.Header{
-										"Accept-Encoding": []string{"gzip"},
-									},
-								}
-								return nil
-							}
-
-							if opts.Header == nil {
-								opts.Header = http.Header{
-									"Accept-Encoding": []string{"gzip"},
-								}
-								return nil
-							}
-
-							if encoding := opts.Header.Get("Accept-Encoding"); encoding == "" {
-								opts.Header.Add("Accept-Encoding", "gzip")
-								return nil
-							}
-
-							return nil
-						}
-					},
-				})
-
-				break L
+					if opts.Header.Get("Accept-Encoding") == "" {
+						opts.Header.Set("Accept-Encoding", encoding)
+					}
+					return push(target, opts)
+				}
+			},
+		}), r)
+		reportRoute(r)
+
+		cw.Close()
+	})
+}
+
+// compressWriter holds back the status code and the start of the response body
+// until it knows whether the response should be compressed.
+type compressWriter struct {
+	rw        http.ResponseWriter
+	req       *http.Request
+	opts      *compressOptions
+	encoding  string
+	sensitive *atomic.Bool
+	// held lists the entity tags of the request that were varied by varyETag,
+	// without the variation. The client holds their compressed representation.
+	held []string
+	// head is set for HEAD requests, which only get the headers of a
+	// compressed response.
+	head bool
+
+	status  int
+	buf     []byte
+	decided bool
+
+	pool    *sync.Pool
+	cw      compressor
+	out     countingWriter
+	in      int64
+	elapsed time.Duration
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.decided {
+		c.rw.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		c.rw.WriteHeader(code)
+		return
+	}
+	if c.status == 0 {
+		c.status = code
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		if len(c.buf)+len(b) < c.opts.minSize {
+			c.buf = append(c.buf, b...)
+			return len(b), nil
+		}
+		if err := c.decideFor(b, true); err != nil {
+			return 0, err
+		}
+	}
+	if c.cw != nil {
+		return c.encode(b)
+	}
+	return c.rw.Write(b)
+}
+
+// encode writes b to the compressor, keeping track of the stats.
+func (c *compressWriter) encode(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.cw.Write(b)
+	c.elapsed += time.Since(start)
+	c.in += int64(n)
+	return n, err
+}
+
+// timed calls fn, which flushes or closes the compressor, keeping track of the
+// time it took.
+func (c *compressWriter) timed(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	c.elapsed += time.Since(start)
+	return err
+}
+
+// Flush writes out the buffered response and flushes the compressor, the caller
+// is expected to flush the underlying response writer afterwards.
+func (c *compressWriter) Flush() error {
+	if !c.decided {
+		if err := c.decideFor(nil, true); err != nil {
+			return err
+		}
+	}
+	if c.cw != nil {
+		return c.timed(c.cw.Flush)
+	}
+	return nil
+}
+
+// decide settles on sending the response as is when nothing was decided yet,
+// unless the buffered body reached the minimum size.
+func (c *compressWriter) decide() error {
+	if c.decided {
+		return nil
+	}
+	large := len(c.buf) > 0 && len(c.buf) >= c.opts.minSize
+	if c.head && len(c.buf) == 0 {
+		// there is no body to a HEAD response, the Content-Length tells how
+		// large the body of a GET would be.
+		n, err := strconv.ParseInt(c.rw.Header().Get("Content-Length"), 10, 64)
+		large = err != nil || n > 0 && n >= int64(c.opts.minSize)
+	}
+	return c.decideFor(nil, large)
+}
+
+// decideFor decides whether to compress the response, writes the headers and
+// the buffered body. The next bytes are used to sniff the content type when
+// nothing was buffered.
+func (c *compressWriter) decideFor(next []byte, large bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	h := c.rw.Header()
+	if sniff := c.buf; h.Get("Content-Type") == "" {
+		if len(sniff) == 0 {
+			sniff = next
+		}
+		if len(sniff) > 0 {
+			h.Set("Content-Type", http.DetectContentType(sniff))
+		}
+	}
+
+	if large && c.shouldCompress(h) {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		varyETag(h, c.encoding, c.opts.weakETags)
+		if c.opts.padding > 0 {
+			h.Set(paddingHeader, padding(c.opts.padding))
+		}
+
+		if !c.head {
+			c.pool = compressorPool(c.encoding, c.opts.level)
+			c.cw = c.pool.Get().(compressor)
+			c.out = countingWriter{w: c.rw}
+			c.cw.Reset(&c.out)
+		}
+	}
+	if c.status == http.StatusNotModified && slices.Contains(c.held, h.Get("ETag")) && c.compressible(h) {
+		// the client holds the compressed representation, so it needs to see
+		// the ETag of that representation.
+		varyETag(h, c.encoding, c.opts.weakETags)
+	}
+	c.rw.WriteHeader(c.status)
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+	buf := c.buf
+	c.buf = nil
+	if c.cw != nil {
+		_, err := c.encode(buf)
+		return err
+	}
+	_, err := c.rw.Write(buf)
+	return err
+}
+
+func (c *compressWriter) shouldCompress(h http.Header) bool {
+	switch c.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	return c.compressible(h)
+}
+
+// compressible reports whether a response with these headers gets compressed,
+// regardless of its status code.
+func (c *compressWriter) compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" || c.sensitive.Load() {
+		return false
+	}
+
+	ct := h.Get("Content-Type")
+	if ct == "" && c.status == http.StatusNotModified {
+		// a 304 usually goes without a Content-Type, so there is nothing left to
+		// filter on.
+		return true
+	}
+	if c.opts.skipStreams && (strings.Contains(ct, "text/event-stream") || h.Get("Transfer-Encoding") == "chunked") {
+		return false
+	}
+
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(ct))
+	}
+	if matchContentType(mt, c.opts.skipTypes) {
+		return false
+	}
+	return len(c.opts.types) == 0 || matchContentType(mt, c.opts.types)
+}
+
+// varyETag turns the ETag of the uncompressed response into the ETag of the
+// representation compressed with encoding, or weakens it.
+func varyETag(h http.Header, encoding string, weak bool) {
+	etag := h.Get("ETag")
+	switch {
+	case etag == "" || strings.HasPrefix(etag, "W/"):
+	case weak:
+		h.Set("ETag", "W/"+etag)
+	case len(etag) > 1 && strings.HasSuffix(etag, `"`):
+		h.Set("ETag", etag[:len(etag)-1]+"-"+encoding+`"`)
+	}
+}
+
+// stripETagSuffixes removes the suffix of encoding added by varyETag from the
+// entity tags in the conditional headers of r, so handlers can compare them
+// with the ETag of the uncompressed response. It returns a shallow copy of r
+// when a header needs to change, and the stripped entity tags.
+func stripETagSuffixes(r *http.Request, encoding string) (*http.Request, []string) {
+	var held []string
+	var header http.Header
+	for _, name := range []string{"If-Match", "If-None-Match"} {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+
+		stripped := make([]string, 0, len(values))
+		changed := false
+		for v := range slices.Values(values) {
+			tags := strings.Split(v, ",")
+			for i, tag := range tags {
+				tag = strings.TrimSpace(tag)
+				if t, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok && !strings.HasPrefix(tag, "W/") {
+					tags[i] = t + `"`
+					held = append(held, tags[i])
+					changed = true
+				}
+			}
+			stripped = append(stripped, strings.Join(tags, ","))
+		}
+		if !changed {
+			continue
+		}
+
+		if header == nil {
+			header = r.Header.Clone()
+		}
+		header[name] = stripped
+	}
+	if header == nil {
+		return r, nil
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = header
+	return r2, held
+}
+
+// weakETags returns the weak entity tags in the conditional headers of r as
+// strong ones, the ones CompressWeakETags turned weak.
+func weakETags(r *http.Request) []string {
+	var held []string
+	for _, name := range []string{"If-Match", "If-None-Match"} {
+		for v := range slices.Values(r.Header.Values(name)) {
+			for tag := range strings.SplitSeq(v, ",") {
+				if t, ok := strings.CutPrefix(strings.TrimSpace(tag), "W/"); ok {
+					held = append(held, t)
+				}
 			}
 		}
+	}
+	return held
+}
 
-		h.ServeHTTP(w, r)
-	})
+// Close writes what's left of the response and returns the compressor to its
+// pool.
+func (c *compressWriter) Close() {
+	if !c.decided && (c.status != 0 || len(c.buf) > 0) {
+		if err := c.decide(); err != nil {
+			requestLogger(c.req.Context(), nil).Error("writing buffered response", slogx.Error(err))
+		}
+	}
+	if c.cw == nil {
+		return
+	}
+	if err := c.timed(c.cw.Close); err != nil {
+		requestLogger(c.req.Context(), nil).Error("closing "+c.encoding+" writer", slogx.Error(err))
+	}
+	c.pool.Put(c.cw)
+	c.cw = nil
+
+	stats := CompressStats{
+		Encoding:     c.encoding,
+		Uncompressed: c.in,
+		Compressed:   c.out.n,
+		Duration:     c.elapsed - c.out.elapsed,
+	}
+	recordCompressStats(stats)
+	if c.opts.observe != nil {
+		c.opts.observe(c.req, stats)
+	}
+}
+
+func matchContentType(mediaType string, patterns []string) bool {
+	for pattern := range slices.Values(patterns) {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// compressVars publishes the totals of all compressed responses per
+// content-coding, under the "middlewares.compress" expvar.
+var compressVars = expvar.NewMap("middlewares.compress")
+
+func init() {
+	for enc := range slices.Values(defaultCompressEncodings) {
+		compressVars.Set(enc, new(expvar.Map).Init())
+	}
+}
+
+// CompressStats describes the compression of a single response.
+type CompressStats struct {
+	// Encoding is the content-coding of the response.
+	Encoding string
+	// Uncompressed is the number of bytes the handler wrote.
+	Uncompressed int64
+	// Compressed is the number of bytes sent to the client.
+	Compressed int64
+	// Duration is the time spent compressing, excluding the time spent writing
+	// to the client.
+	Duration time.Duration
+}
+
+// Ratio returns the compressed size as a fraction of the uncompressed size.
+func (s CompressStats) Ratio() float64 {
+	if s.Uncompressed == 0 {
+		return 0
+	}
+	return float64(s.Compressed) / float64(s.Uncompressed)
+}
+
+// CompressObserver registers a callback that is called with the stats of every
+// compressed response, after the response is written.
+func CompressObserver(observe func(*http.Request, CompressStats)) CompressOption {
+	return func(o *compressOptions) {
+		o.observe = observe
+	}
+}
+
+// recordCompressStats adds the stats of a response to the expvar totals.
+func recordCompressStats(stats CompressStats) {
+	vars, ok := compressVars.Get(stats.Encoding).(*expvar.Map)
+	if !ok {
+		return
+	}
+	vars.Add("responses", 1)
+	vars.Add("uncompressed_bytes", stats.Uncompressed)
+	vars.Add("compressed_bytes", stats.Compressed)
+	vars.Add("compress_ns", stats.Duration.Nanoseconds())
+}
+
+// countingWriter counts the bytes written to w and the time it took.
+type countingWriter struct {
+	w       io.Writer
+	n       int64
+	elapsed time.Duration
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.w.Write(b)
+	c.elapsed += time.Since(start)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressObserver(t *testing.T) {
+	vars := compressVars.Get(gzipa).(*expvar.Map)
+	responses := expvarInt(vars, "responses")
+	compressed := expvarInt(vars, "compressed_bytes")
+
+	var observed []CompressStats
+	h := CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		for range 1024 {
+			_, _ = io.WriteString(w, "Gorilla!\n")
+		}
+	}), CompressObserver(func(r *http.Request, stats CompressStats) {
+		require.NotNil(t, r)
+		observed = append(observed, stats)
+	}))
+
+	w := serveCompressed(h, "gzip")
+	require.Len(t, observed, 1)
+	stats := observed[0]
+	assert.Equal(t, gzipa, stats.Encoding)
+	assert.EqualValues(t, 9*1024, stats.Uncompressed)
+	assert.EqualValues(t, w.Body.Len(), stats.Compressed)
+	assert.Positive(t, stats.Duration)
+	assert.Less(t, stats.Ratio(), 0.1)
+
+	assert.Equal(t, responses+1, expvarInt(vars, "responses"))
+	assert.Equal(t, compressed+stats.Compressed, expvarInt(vars, "compressed_bytes"))
+
+	// uncompressed responses aren't observed
+	serveCompressed(h, "")
+	assert.Len(t, observed, 1)
+	assert.Equal(t, responses+1, expvarInt(vars, "responses"))
+	assert.Contains(t, expvar.Get("middlewares.compress").String(), `"zstd"`)
+}
+
+func expvarInt(vars *expvar.Map, key string) int64 {
+	if v, ok := vars.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -4,12 +4,19 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Taken from http://github.com/gorilla/handlers
@@ -79,6 +86,50 @@ func TestCompressHandlerDeflate(t *testing.T) {
 	}
 }
 
+func TestCompressHandlerZstd(t *testing.T) {
+	w := httptest.NewRecorder()
+	compressedRequest(w, "zstd")
+	if w.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("wrong content encoding, got %q want %q", w.Header().Get("Content-Encoding"), "zstd")
+	}
+	if w.Header().Get("Content-Type") != contentType {
+		t.Fatalf("wrong content type, got %s want %s", w.Header().Get("Content-Type"), contentType)
+	}
+
+	zr, err := zstd.NewReader(w.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	b, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 1024), string(b))
+}
+
+func TestCompressHandlerBrotli(t *testing.T) {
+	w := httptest.NewRecorder()
+	compressedRequest(w, "br")
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("wrong content encoding, got %q want %q", w.Header().Get("Content-Encoding"), "br")
+	}
+	if w.Header().Get("Content-Type") != contentType {
+		t.Fatalf("wrong content type, got %s want %s", w.Header().Get("Content-Type"), contentType)
+	}
+
+	b, err := io.ReadAll(brotli.NewReader(w.Body))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 1024), string(b))
+}
+
+func TestCompressorPoolLevels(t *testing.T) {
+	for _, enc := range []string{gzipa, deflate, zstda, br} {
+		for level := gzip.DefaultCompression; level <= gzip.BestCompression; level++ {
+			pool := compressorPool(enc, level)
+			require.NotNil(t, pool, "%s level %d", enc, level)
+			assert.Implements(t, (*compressor)(nil), pool.Get(), "%s level %d", enc, level)
+		}
+	}
+	assert.Nil(t, compressorPool("compress", gzip.DefaultCompression))
+}
+
 func TestCompressHandlerGzipDeflate(t *testing.T) {
 	w := httptest.NewRecorder()
 	compressedRequest(w, "gzip, deflate ")
@@ -90,20 +141,55 @@ func TestCompressHandlerGzipDeflate(t *testing.T) {
 	}
 }
 
-func TestCompressHandlerSSE(t *testing.T) {
-	w := httptest.NewRecorder()
-	CompressHandlerLevel(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func sseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 		w.WriteHeader(http.StatusOK)
 		_, _ = io.WriteString(w, "data: test\n\n")
-	}), gzip.BestCompression).ServeHTTP(w, &http.Request{
-		Method: "GET",
-		Header: http.Header{
-			"Accept-Encoding": []string{"gzip"},
-		},
 	})
+}
+
+func chunkedHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("ResponseWriter does not implement http.Flusher")
+		}
+		_, _ = io.WriteString(w, "chunk1\n")
+		flusher.Flush()
+		_, _ = io.WriteString(w, "chunk2\n")
+		flusher.Flush()
+	})
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	t.Helper()
+	gr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	b, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestCompressHandlerSSE(t *testing.T) {
+	w := serveCompressed(CompressHandlerLevel(sseHandler(), gzip.BestCompression), "gzip")
+
+	if enc := w.Header().Get("Content-Encoding"); enc != gzipa {
+		t.Errorf("wrong content encoding for SSE, got %q want %q", enc, gzipa)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong content type, got %q want %q", ct, "text/event-stream")
+	}
+	assert.Equal(t, "data: test\n\n", gunzip(t, w.Body))
+}
+
+func TestCompressHandlerSSESkipStreams(t *testing.T) {
+	w := serveCompressed(CompressHandlerWithOptions(sseHandler(), SkipCompressStreams()), "gzip")
 
 	// Verify compression was skipped for SSE
 	if enc := w.Header().Get("Content-Encoding"); enc != "" {
@@ -119,25 +205,17 @@ func TestCompressHandlerSSE(t *testing.T) {
 }
 
 func TestCompressHandlerChunked(t *testing.T) {
-	w := httptest.NewRecorder()
-	CompressHandlerLevel(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Transfer-Encoding", "chunked")
-		w.Header().Set("Content-Type", contentType)
-		w.WriteHeader(http.StatusOK)
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			t.Fatal("ResponseWriter does not implement http.Flusher")
-		}
-		_, _ = io.WriteString(w, "chunk1\n")
-		flusher.Flush()
-		_, _ = io.WriteString(w, "chunk2\n")
-		flusher.Flush()
-	}), gzip.BestCompression).ServeHTTP(w, &http.Request{
-		Method: "GET",
-		Header: http.Header{
-			"Accept-Encoding": []string{"gzip"},
-		},
-	})
+	w := serveCompressed(CompressHandlerLevel(chunkedHandler(t), gzip.BestCompression), "gzip")
+
+	if enc := w.Header().Get("Content-Encoding"); enc != gzipa {
+		t.Errorf("wrong content encoding for chunked response, got %q want %q", enc, gzipa)
+	}
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "chunk1\nchunk2\n", gunzip(t, w.Body))
+}
+
+func TestCompressHandlerChunkedSkipStreams(t *testing.T) {
+	w := serveCompressed(CompressHandlerWithOptions(chunkedHandler(t), SkipCompressStreams()), "gzip")
 
 	// Verify compression was skipped for chunked response
 	if enc := w.Header().Get("Content-Encoding"); enc != "" {
@@ -152,6 +230,57 @@ func TestCompressHandlerChunked(t *testing.T) {
 	}
 }
 
+func TestCompressHandlerFlushStreams(t *testing.T) {
+	for _, enc := range []string{gzipa, deflate, zstda, br} {
+		t.Run(enc, func(t *testing.T) {
+			next := make(chan struct{})
+			srv := httptest.NewServer(CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i := range 3 {
+					_, _ = io.WriteString(w, "data: "+strconv.Itoa(i)+"\n\n")
+					w.(http.Flusher).Flush()
+					<-next
+				}
+			}), CompressMinSize(1024)))
+			defer srv.Close()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", enc)
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, enc, resp.Header.Get("Content-Encoding"))
+
+			var body io.Reader
+			switch enc {
+			case gzipa:
+				body, err = gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+			case deflate:
+				body = flate.NewReader(resp.Body)
+			case zstda:
+				zr, err := zstd.NewReader(resp.Body)
+				require.NoError(t, err)
+				defer zr.Close()
+				body = zr
+			case br:
+				body = brotli.NewReader(resp.Body)
+			}
+
+			// every event must be readable before the handler produces the next one
+			for i := range 3 {
+				expected := "data: " + strconv.Itoa(i) + "\n\n"
+				b := make([]byte, len(expected))
+				_, err := io.ReadFull(body, b)
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(b))
+				next <- struct{}{}
+			}
+		})
+	}
+}
+
 func TestSetAcceptEncodingForPushOptionsWithoutHeaders(t *testing.T) {
 	var opts *http.PushOptions
 	opts = setAcceptEncodingForPushOptions(opts)
@@ -237,3 +366,218 @@ func TestSetAcceptEncodingForPushOptionsWithHeaders(t *testing.T) {
 	assert.Equal(t, "deflate", e[0])
 	assert.Equal(t, "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/57.0.2987.98 Safari/537.36", opts.Header.Get("User-Agent"))
 }
+
+func serveCompressed(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCompressHandlerMinSize(t *testing.T) {
+	h := CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusCreated)
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		for range n {
+			_, _ = io.WriteString(w, "Gorilla!\n")
+		}
+	}), CompressMinSize(64))
+
+	r := httptest.NewRequest("GET", "/?n=4", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 4), w.Body.String())
+
+	r = httptest.NewRequest("GET", "/?n=8", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 8), gunzip(t, w.Body))
+}
+
+func TestCompressHandlerContentTypes(t *testing.T) {
+	handlerFor := func(ct string, opts ...CompressOption) http.Handler {
+		return CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			_, _ = io.WriteString(w, strings.Repeat("Gorilla!\n", 100))
+		}), opts...)
+	}
+
+	tests := []struct {
+		name       string
+		ct         string
+		opts       []CompressOption
+		compressed bool
+	}{
+		{name: "default text", ct: contentType, compressed: true},
+		{name: "sniffed text", ct: "", compressed: true},
+		{name: "default png", ct: "image/png", compressed: false},
+		{name: "default video wildcard", ct: "video/mp4", compressed: false},
+		{name: "svg", ct: "image/svg+xml", compressed: true},
+		{name: "allowed", ct: "application/vnd.api+json", opts: []CompressOption{CompressContentTypes("application/*+json")}, compressed: true},
+		{name: "not allowed", ct: "text/csv", opts: []CompressOption{CompressContentTypes("application/*+json", "text/html")}, compressed: false},
+		{name: "denied", ct: "text/csv; charset=utf-8", opts: []CompressOption{SkipCompressContentTypes("Text/CSV")}, compressed: false},
+		{name: "denied wins", ct: "text/csv", opts: []CompressOption{CompressContentTypes("text/*"), SkipCompressContentTypes("text/csv")}, compressed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveCompressed(handlerFor(tt.ct, tt.opts...), "gzip")
+			if tt.compressed {
+				assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+				return
+			}
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, strings.Repeat("Gorilla!\n", 100), w.Body.String())
+		})
+	}
+}
+
+func TestCompressHandlerSkip(t *testing.T) {
+	h := CompressHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("Gorilla!\n", 100))
+	}), SkipCompress(func(r *http.Request) bool {
+		return r.Header.Get("X-Skip") != ""
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{
+		Method: "GET",
+		Header: http.Header{"Accept-Encoding": []string{"gzip"}, "X-Skip": []string{"1"}},
+	})
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	w = serveCompressed(h, "gzip")
+	assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+}
+
+func TestCompressHandlerReadFrom(t *testing.T) {
+	srv := httptest.NewServer(CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.Copy(w, strings.NewReader(strings.Repeat("Gorilla!\n", 1024)))
+	})))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.True(t, resp.Uncompressed)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("Gorilla!\n", 1024), string(b))
+}
+
+func TestCompressHandlerETags(t *testing.T) {
+	content := strings.Repeat("Gorilla!\n", 1024)
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "gorilla.txt", time.Time{}, strings.NewReader(content))
+	}
+	h := CompressHandler(http.HandlerFunc(serveContent))
+
+	do := func(h http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+		header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, &http.Request{Method: method, URL: &url.URL{Path: "/"}, Header: header})
+		return w
+	}
+
+	t.Run("compressed", func(t *testing.T) {
+		w := do(h, "GET", http.Header{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, content, gunzip(t, w.Body))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		w := do(h, "GET", http.Header{"If-None-Match": []string{`"def", "abc-gzip"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+		assert.Zero(t, w.Body.Len())
+	})
+
+	t.Run("suffixes", func(t *testing.T) {
+		w := do(h, "GET", http.Header{"If-None-Match": []string{`"abc-br"`}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+		w = do(h, "GET", http.Header{"If-None-Match": []string{`"def-gzip"`}})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("not modified uncompressed", func(t *testing.T) {
+		w := do(h, "GET", http.Header{"If-None-Match": []string{`"abc"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+
+		png := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			serveContent(w, r)
+		}))
+		w = do(png, "GET", http.Header{})
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+		w = do(png, "GET", http.Header{"If-None-Match": []string{`"abc"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		w := do(h, "GET", http.Header{"Range": []string{"bytes=0-8"}})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+		assert.Equal(t, "Gorilla!\n", w.Body.String())
+	})
+
+	t.Run("head", func(t *testing.T) {
+		w := do(h, "HEAD", http.Header{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Zero(t, w.Body.Len())
+
+		small := CompressHandlerWithOptions(http.HandlerFunc(serveContent), CompressMinSize(len(content)+1))
+		w = do(small, "HEAD", http.Header{})
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+		assert.Equal(t, strconv.Itoa(len(content)), w.Header().Get("Content-Length"))
+	})
+
+	t.Run("weak", func(t *testing.T) {
+		h := CompressHandlerWithOptions(http.HandlerFunc(serveContent), CompressWeakETags())
+		w := do(h, "GET", http.Header{})
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+
+		w = do(h, "GET", http.Header{"If-None-Match": []string{`W/"abc"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+	})
+}
+
+func TestCompressHandlerNoContent(t *testing.T) {
+	w := serveCompressed(CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})), "gzip")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Zero(t, w.Body.Len())
+}
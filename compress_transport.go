@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// defaultCompressRequestMinSize is the default minimum size of a request body
+// before CompressingTransport compresses it.
+const defaultCompressRequestMinSize = 1024
+
+// CompressRequestMinSize sets the minimum size of a request body before it gets
+// compressed. Request bodies of unknown length are always compressed.
+func CompressRequestMinSize(size int64) func(*compressingTransport) {
+	return func(t *compressingTransport) {
+		t.minSize = size
+	}
+}
+
+// CompressRequestEncoding sets the content-coding used for request bodies, gzip
+// by default. An empty encoding disables compressing request bodies.
+func CompressRequestEncoding(encoding string) func(*compressingTransport) {
+	return func(t *compressingTransport) {
+		t.encoding = strings.ToLower(encoding)
+	}
+}
+
+// AcceptResponseEncodings sets the content-codings advertised in the
+// Accept-Encoding header of requests, zstd, br and gzip by default. Passing no
+// encodings leaves the Accept-Encoding header to the wrapped transport.
+func AcceptResponseEncodings(encodings ...string) func(*compressingTransport) {
+	return func(t *compressingTransport) {
+		t.accept = lowerAll(encodings)
+	}
+}
+
+// CompressingTransport decorates an existing transport with compression of
+// request bodies and decompression of responses.
+//
+// Request bodies without a Content-Encoding that are at least 1KB, or of unknown
+// length, are compressed while they are sent. Requests without an
+// Accept-Encoding or Range header advertise zstd, br and gzip, and responses in
+// those encodings are decoded transparently, like http.Transport does for gzip.
+func CompressingTransport(toWrap http.RoundTripper, opts ...func(*compressingTransport)) http.RoundTripper {
+	tr := &compressingTransport{
+		w:        toWrap,
+		minSize:  defaultCompressRequestMinSize,
+		encoding: gzipa,
+		accept:   []string{zstda, br, gzipa},
+	}
+	for opt := range slices.Values(opts) {
+		opt(tr)
+	}
+	tr.accept = slices.DeleteFunc(tr.accept, func(enc string) bool {
+		return decompressorPool(enc) == nil
+	})
+	return tr
+}
+
+type compressingTransport struct {
+	w        http.RoundTripper
+	minSize  int64
+	encoding string
+	accept   []string
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	outReq := req
+	if t.shouldCompress(req) {
+		outReq = req.Clone(req.Context())
+		outReq.Body = t.compressBody(req.Body)
+		if req.GetBody != nil {
+			outReq.GetBody = func() (io.ReadCloser, error) {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return t.compressBody(body), nil
+			}
+		}
+		outReq.ContentLength = -1
+		outReq.Header.Del("Content-Length")
+		outReq.Header.Set("Content-Encoding", t.encoding)
+	}
+
+	decode := len(t.accept) > 0 && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == ""
+	if decode {
+		if outReq == req {
+			outReq = req.Clone(req.Context())
+		}
+		outReq.Header.Set("Accept-Encoding", strings.Join(t.accept, ", "))
+	}
+
+	resp, err := t.w.RoundTrip(outReq)
+	if err != nil || !decode {
+		return resp, err
+	}
+
+	pool := decompressorPool(strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))))
+	if pool == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, nil
+	}
+	resp.Body = &decompressBody{d: pool.Get().(decompressor), pool: pool, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+func (t *compressingTransport) shouldCompress(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if compressorPool(t.encoding, gzip.DefaultCompression) == nil {
+		return false
+	}
+	// a client request with a body and a content length of 0 has an unknown
+	// length.
+	return req.ContentLength <= 0 || req.ContentLength >= t.minSize
+}
+
+// compressBody returns a reader with the compressed body, the compression
+// happens while the transport reads it.
+func (t *compressingTransport) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pool := compressorPool(t.encoding, gzip.DefaultCompression)
+		cw := pool.Get().(compressor)
+		cw.Reset(pw)
+
+		_, err := io.Copy(cw, body)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+		pool.Put(cw)
+		_ = body.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressingTransport(t *testing.T) {
+	body := strings.Repeat("Gorilla!\n", 1024)
+
+	var requestEncoding, acceptEncoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestEncoding = r.Header.Get("Content-Encoding")
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		DecompressRequest(CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(w, r.Body)
+		}))).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	post := func(client *http.Client, body string, header http.Header) *http.Response {
+		req, err := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for _, enc := range []string{gzipa, zstda} {
+		t.Run(enc, func(t *testing.T) {
+			client := &http.Client{Transport: CompressingTransport(srv.Client().Transport, CompressRequestEncoding(enc))}
+			resp := post(client, body, nil)
+			defer resp.Body.Close()
+
+			assert.Equal(t, enc, requestEncoding)
+			assert.Equal(t, "zstd, br, gzip", acceptEncoding)
+			assert.True(t, resp.Uncompressed)
+			assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(b))
+		})
+	}
+
+	t.Run("brotli response", func(t *testing.T) {
+		client := &http.Client{Transport: CompressingTransport(srv.Client().Transport, AcceptResponseEncodings("br"))}
+		resp := post(client, body, nil)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "br", acceptEncoding)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	})
+
+	t.Run("small body", func(t *testing.T) {
+		client := &http.Client{Transport: CompressingTransport(srv.Client().Transport)}
+		resp := post(client, "hello", nil)
+		defer resp.Body.Close()
+
+		assert.Empty(t, requestEncoding)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	})
+
+	t.Run("caller accept encoding", func(t *testing.T) {
+		client := &http.Client{Transport: CompressingTransport(srv.Client().Transport)}
+		resp := post(client, body, http.Header{"Accept-Encoding": []string{"br"}})
+		defer resp.Body.Close()
+
+		assert.Equal(t, "br", acceptEncoding)
+		assert.Equal(t, br, resp.Header.Get("Content-Encoding"))
+		assert.False(t, resp.Uncompressed)
+	})
+
+	t.Run("retry uses GetBody", func(t *testing.T) {
+		req, err := http.NewRequest("POST", srv.URL, bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		tr := CompressingTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			_ = r.Body.Close()
+			require.NotNil(t, r.GetBody)
+			b, err := r.GetBody()
+			require.NoError(t, err)
+			r.Body = b
+			return srv.Client().Transport.RoundTrip(r)
+		}))
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	})
+}
+
+func TestCompressingTransportNoBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: CompressingTransport(srv.Client().Transport)}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, b)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
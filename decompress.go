@@ -0,0 +1,206 @@
+package middlewares
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/casualjim/middlewares/slogx"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultDecompressMaxSize is the default limit on the decompressed size of a
+// request body.
+const defaultDecompressMaxSize = 32 << 20
+
+// zstdMaxWindow is the largest window size RFC 9659 requires decoders of the
+// zstd content-coding to support, larger windows are refused to bound memory.
+const zstdMaxWindow = 8 << 20
+
+// decompressor is the common interface of the pooled gzip, flate, zstd and
+// brotli readers.
+type decompressor interface {
+	io.Reader
+	Reset(io.Reader) error
+}
+
+var (
+	gzipReaderPool = &sync.Pool{
+		New: func() interface{} {
+			return new(gzip.Reader)
+		},
+	}
+	flateReaderPool = &sync.Pool{
+		New: func() interface{} {
+			return &flateReader{
+				br: bufio.NewReader(nil),
+				fr: flate.NewReader(nil),
+			}
+		},
+	}
+	zstdReaderPool = &sync.Pool{
+		New: func() interface{} {
+			// NewReader only returns error on invalid options, we are guaranteeing
+			// valid options so it is okay to ignore the returned error.
+			r, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			return r
+		},
+	}
+	brotliReaderPool = &sync.Pool{
+		New: func() interface{} {
+			return brotli.NewReader(nil)
+		},
+	}
+)
+
+// decompressorPool returns the reader pool for the given content-coding, or nil
+// when the coding isn't supported.
+func decompressorPool(encoding string) *sync.Pool {
+	switch encoding {
+	case gzipa, "x-gzip":
+		return gzipReaderPool
+	case deflate:
+		return flateReaderPool
+	case zstda:
+		return zstdReaderPool
+	case br:
+		return brotliReaderPool
+	default:
+		return nil
+	}
+}
+
+// flateReader decodes the deflate content-coding. RFC 9110 defines it as a zlib
+// stream, but plenty of clients send raw deflate data, so the zlib header is
+// skipped when present. The adler32 checksum of zlib streams isn't verified.
+type flateReader struct {
+	br *bufio.Reader
+	fr io.ReadCloser
+}
+
+func (f *flateReader) Reset(r io.Reader) error {
+	f.br.Reset(r)
+	if hdr, err := f.br.Peek(2); err == nil && isZlibHeader(hdr) {
+		_, _ = f.br.Discard(2)
+	}
+	return f.fr.(flate.Resetter).Reset(f.br, nil)
+}
+
+func (f *flateReader) Read(p []byte) (int, error) {
+	return f.fr.Read(p)
+}
+
+// isZlibHeader reports whether hdr is a zlib header for a deflate stream without
+// a preset dictionary.
+func isZlibHeader(hdr []byte) bool {
+	return hdr[0]&0x0f == 8 && hdr[0]>>4 <= 7 && hdr[1]&0x20 == 0 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0
+}
+
+// decompressBody reads the decoded body and returns the decoder to its pool when
+// it is closed. The decoder is reset to the body on the first read, unless that
+// happened already.
+type decompressBody struct {
+	d     decompressor
+	pool  *sync.Pool
+	body  io.ReadCloser
+	reset bool
+	err   error
+	once  sync.Once
+}
+
+func (b *decompressBody) Read(p []byte) (int, error) {
+	if !b.reset {
+		b.reset = true
+		b.err = b.d.Reset(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.d.Read(p)
+}
+
+func (b *decompressBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		b.pool.Put(b.d)
+	})
+	return err
+}
+
+// DecompressOption configures the handler created by DecompressRequest.
+type DecompressOption func(*decompressOptions)
+
+type decompressOptions struct {
+	maxSize int64
+}
+
+// DecompressMaxSize sets the maximum size of a decompressed request body.
+func DecompressMaxSize(size int64) DecompressOption {
+	return func(o *decompressOptions) {
+		o.maxSize = size
+	}
+}
+
+// DecompressRequest transparently decodes request bodies that are sent with a
+// gzip, deflate, zstd or br Content-Encoding, so handlers read the original
+// body. The Content-Encoding and Content-Length headers are removed from the
+// request the handler sees.
+//
+// The decompressed body is limited to 32MB unless configured otherwise, to
+// defend against zip bombs. Reading past the limit fails with an
+// *http.MaxBytesError. Requests with an unsupported Content-Encoding are
+// refused with 415 Unsupported Media Type, and those with a corrupt body with
+// 400 Bad Request.
+func DecompressRequest(h http.Handler, opts ...DecompressOption) http.Handler {
+	o := &decompressOptions{
+		maxSize: defaultDecompressMaxSize,
+	}
+	for opt := range slices.Values(opts) {
+		opt(o)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(strings.Join(r.Header.Values("Content-Encoding"), ",")))
+		if encoding == "" || encoding == identity || r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		pool := decompressorPool(encoding)
+		if pool == nil {
+			w.Header().Set("Accept-Encoding", strings.Join(defaultCompressEncodings, ", "))
+			JSONError(w, "unsupported content encoding "+encoding, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		d := pool.Get().(decompressor)
+		if err := d.Reset(r.Body); err != nil {
+			pool.Put(d)
+			JSONError(w, "invalid "+encoding+" request body", http.StatusBadRequest)
+			return
+		}
+		body := &decompressBody{d: d, pool: pool, body: r.Body, reset: true}
+		defer func() {
+			if err := body.Close(); err != nil {
+				requestLogger(r.Context(), nil).Debug("closing request body", slogx.Error(err))
+			}
+		}()
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Header = r.Header.Clone()
+		r2.Header.Del("Content-Encoding")
+		r2.Header.Del("Content-Length")
+		r2.ContentLength = -1
+		r2.Body = http.MaxBytesReader(w, body, o.maxSize)
+
+		h.ServeHTTP(w, r2)
+		reportRoute(r2)
+	})
+}
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeBody(t *testing.T, encoding, body string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case gzipa:
+		w = gzip.NewWriter(&buf)
+	case deflate:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case zstda:
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		require.NoError(t, err)
+	case br:
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	_, err := io.WriteString(w, body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func echoBody(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		assert.Empty(t, r.Header.Get("Content-Length"))
+		b, err := io.ReadAll(r.Body)
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			JSONError(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		require.NoError(t, err)
+		_, _ = w.Write(b)
+	})
+}
+
+func TestDecompressRequest(t *testing.T) {
+	body := strings.Repeat("Gorilla!\n", 1024)
+	tests := []struct {
+		name     string
+		encoding string
+		header   string
+	}{
+		{name: "gzip", encoding: gzipa, header: "gzip"},
+		{name: "x-gzip", encoding: gzipa, header: "x-gzip"},
+		{name: "raw deflate", encoding: deflate, header: "deflate"},
+		{name: "zlib deflate", encoding: "zlib", header: "deflate"},
+		{name: "zstd", encoding: zstda, header: "zstd"},
+		{name: "brotli", encoding: br, header: "BR"},
+	}
+
+	h := DecompressRequest(echoBody(t))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// run twice so pooled readers get reused
+			for range 2 {
+				r := httptest.NewRequest("POST", "/", bytes.NewReader(encodeBody(t, tt.encoding, body)))
+				r.Header.Set("Content-Encoding", tt.header)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecompressRequestIdentity(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	r.Header.Set("Content-Encoding", "identity")
+	w := httptest.NewRecorder()
+	DecompressRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(b)
+	})).ServeHTTP(w, r)
+	assert.Equal(t, "hello", w.Body.String())
+}
+
+func TestDecompressRequestUnsupported(t *testing.T) {
+	for _, enc := range []string{"compress", "gzip, br"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+		r.Header.Set("Content-Encoding", enc)
+		w := httptest.NewRecorder()
+		DecompressRequest(echoBody(t)).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.Equal(t, "zstd, br, gzip, deflate", w.Header().Get("Accept-Encoding"))
+		assert.Contains(t, w.Body.String(), `"code":415`)
+	}
+}
+
+func TestDecompressRequestCorrupt(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("not gzip at all"))
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	DecompressRequest(echoBody(t)).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDecompressRequestMaxSize(t *testing.T) {
+	bomb := encodeBody(t, gzipa, strings.Repeat("\x00", 1<<20))
+	require.Less(t, len(bomb), 2048)
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(bomb))
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	DecompressRequest(echoBody(t), DecompressMaxSize(64<<10)).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -1,45 +1,249 @@
 package middlewares
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var _ error = (*httpError)(nil)
 
+// statusError is implemented by the errors that carry an HTTP status code and
+// response body, it allows finding them in a chain of wrapped errors.
+type statusError interface {
+	error
+	httpStatus() int
+	httpBody() string
+	httpHeader() http.Header
+}
+
 type httpError struct {
 	statusCode int
 	body       string
+	cause      error
+	fields     []FieldError
+	header     http.Header
+	code       Code
+	stack      []uintptr
+}
+
+// FieldError describes why the value of a single field of a request is invalid.
+type FieldError struct {
+	// Field is the path of the field, like "items[0].name".
+	Field string `json:"field"`
+	// Code is a machine readable reason, like "required" or "too_long".
+	Code string `json:"code,omitempty"`
+	// Message is a human readable explanation.
+	Message string `json:"message,omitempty"`
 }
 
 func (e *httpError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("[%d] %s: %v", e.statusCode, e.body, e.cause)
+	}
 	return fmt.Sprintf("[%d] %s", e.statusCode, e.body)
 }
 
+// Unwrap returns the cause of the error, if any.
+func (e *httpError) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an HTTP error with the same status code, so
+// errors.Is(err, Error(http.StatusNotFound, "")) matches any not found error.
+func (e *httpError) Is(target error) bool {
+	t, ok := target.(*httpError)
+	return ok && t.statusCode == e.statusCode
+}
+
+func (e *httpError) httpStatus() int  { return e.statusCode }
+func (e *httpError) httpBody() string { return e.body }
+
+func (e *httpError) httpHeader() http.Header { return e.header }
+
 func Error(statusCode int, body string) error {
-	return &httpError{statusCode: statusCode, body: body}
+	return withStack(&httpError{statusCode: statusCode, body: body}, 1)
+}
+
+// ValidationError creates an HTTP error, typically 400 or 422, with the details
+// of the fields that failed validation. The field errors are rendered as an
+// errors array by RenderJSONError, and as the errors extension member by
+// RenderProblem.
+func ValidationError(statusCode int, body string, fields ...FieldError) error {
+	return withStack(&httpError{statusCode: statusCode, body: body, fields: fields}, 1)
+}
+
+// ErrorWithHeader creates an HTTP error that carries response headers, like
+// Retry-After or WWW-Authenticate. The renderers of HandleErrors, RecoverErrors
+// and NegotiateErrors add them to the error response.
+func ErrorWithHeader(statusCode int, body string, header http.Header) error {
+	return withStack(&httpError{statusCode: statusCode, body: body, header: header}, 1)
 }
 
+// TooManyRequests creates a 429 error that tells the client to retry after the
+// given duration, rounded up to whole seconds. A zero duration omits the
+// Retry-After header.
+func TooManyRequests(retryAfter time.Duration) error {
+	return withStack(&httpError{statusCode: http.StatusTooManyRequests, body: http.StatusText(http.StatusTooManyRequests), header: retryAfterHeader(retryAfter)}, 1)
+}
+
+// ServiceUnavailable creates a 503 error that tells the client to retry after
+// the given duration, rounded up to whole seconds. A zero duration omits the
+// Retry-After header.
+func ServiceUnavailable(retryAfter time.Duration) error {
+	return withStack(&httpError{statusCode: http.StatusServiceUnavailable, body: http.StatusText(http.StatusServiceUnavailable), header: retryAfterHeader(retryAfter)}, 1)
+}
+
+// Unauthorized creates a 401 error with the authentication challenge for the
+// WWW-Authenticate header, like `Bearer realm="api"`. An empty challenge omits
+// the WWW-Authenticate header.
+func Unauthorized(challenge string) error {
+	var header http.Header
+	if challenge != "" {
+		header = http.Header{"Www-Authenticate": {challenge}}
+	}
+	return withStack(&httpError{statusCode: http.StatusUnauthorized, body: http.StatusText(http.StatusUnauthorized), header: header}, 1)
+}
+
+// MethodNotAllowed creates a 405 error with the allowed methods in the Allow
+// header.
+func MethodNotAllowed(allowed ...string) error {
+	return withStack(&httpError{statusCode: http.StatusMethodNotAllowed, body: http.StatusText(http.StatusMethodNotAllowed), header: http.Header{"Allow": {strings.Join(allowed, ", ")}}}, 1)
+}
+
+func retryAfterHeader(d time.Duration) http.Header {
+	if d <= 0 {
+		return nil
+	}
+	return http.Header{"Retry-After": {strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)}}
+}
+
+// Wrap annotates err with a status code and a message that is used as response
+// body, the message defaults to the status text. The error is available as the
+// cause through errors.Unwrap, errors.Is and errors.As, but isn't exposed in the
+// body. Wrap returns nil when err is nil.
+func Wrap(statusCode int, err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if msg == "" {
+		msg = http.StatusText(statusCode)
+	}
+	return withStack(&httpError{statusCode: statusCode, body: msg, cause: err}, 1)
+}
+
+// ErrStatusCode returns the status code of the outermost HTTP error in the chain
+// of e. Without one it returns the status code registered for the error with
+// RegisterError, or 0 when there is none.
 func ErrStatusCode(e error) int {
-	if e == nil {
-		return 0
+	var se statusError
+	if errors.As(e, &se) {
+		return se.httpStatus()
 	}
-	if err, ok := e.(*httpError); ok {
-		return err.statusCode
+	if m, ok := registeredErrors.lookup(e); ok {
+		return m.statusCode
 	}
 	return 0
 }
 
+// ErrBody returns the response body of the outermost HTTP error in the chain of
+// e. Without one it returns the message registered for the error with
+// RegisterError, or an empty string when there is none.
 func ErrBody(e error) string {
-	if e == nil {
-		return ""
+	var se statusError
+	if errors.As(e, &se) {
+		return se.httpBody()
 	}
-	if err, ok := e.(*httpError); ok {
-		return err.body
+	if m, ok := registeredErrors.lookup(e); ok {
+		return m.message
 	}
 	return ""
 }
 
+// ErrFieldErrors returns the field errors of the outermost HTTP error in the
+// chain of e, when it was created by ValidationError or is problem details with
+// an errors extension member.
+func ErrFieldErrors(e error) []FieldError {
+	var se statusError
+	if !errors.As(e, &se) {
+		return nil
+	}
+	switch err := se.(type) {
+	case *httpError:
+		return err.fields
+	case *ProblemDetails:
+		fields, _ := err.Extensions["errors"].([]FieldError)
+		return fields
+	default:
+		return nil
+	}
+}
+
+// ErrHeader returns the response headers carried by the HTTP errors in the chain
+// of e, the values of outer errors take precedence.
+func ErrHeader(e error) http.Header {
+	var header http.Header
+	walkErrors(e, func(err error) {
+		se, ok := err.(statusError)
+		if !ok {
+			return
+		}
+		for k, v := range se.httpHeader() {
+			if header == nil {
+				header = make(http.Header)
+			}
+			if _, exists := header[k]; !exists {
+				header[k] = slices.Clone(v)
+			}
+		}
+	})
+	return header
+}
+
+// walkErrors calls fn for e and every error in its chain, depth first, like
+// errors.As traverses it.
+func walkErrors(e error, fn func(error)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	switch x := e.(type) {
+	case interface{ Unwrap() error }:
+		walkErrors(x.Unwrap(), fn)
+	case interface{ Unwrap() []error }:
+		for err := range slices.Values(x.Unwrap()) {
+			walkErrors(err, fn)
+		}
+	}
+}
+
+// addErrHeader sets the headers carried by err on the response.
+func addErrHeader(w http.ResponseWriter, err error) {
+	for k, v := range ErrHeader(err) {
+		w.Header()[k] = v
+	}
+}
+
+// publicErrBody returns the message of e that is safe to send to clients: the
+// body of client errors and the registered message of server errors, falling
+// back to the status text.
+func publicErrBody(e error, statusCode int) string {
+	var msg string
+	if statusCode < http.StatusInternalServerError {
+		msg = ErrBody(e)
+	} else if m, ok := registeredErrors.lookup(e); ok && !errors.As(e, new(statusError)) {
+		msg = m.message
+	}
+	if msg == "" {
+		return http.StatusText(statusCode)
+	}
+	return msg
+}
+
 func IsBadRequest(e error) bool {
 	return IsError(e, http.StatusBadRequest)
 }
@@ -56,22 +260,17 @@ func IsNotFound(e error) bool {
 	return IsError(e, http.StatusNotFound)
 }
 
+func IsUnprocessable(e error) bool {
+	return IsError(e, http.StatusUnprocessableEntity)
+}
+
 func IsServerError(e error) bool {
-	if e == nil {
-		return false
-	}
-	if err, ok := e.(*httpError); ok {
-		return err.statusCode >= http.StatusInternalServerError
-	}
-	return false
+	return ErrStatusCode(e) >= http.StatusInternalServerError
 }
 
 func IsError(e error, code int) bool {
-	if e == nil {
-		return false
-	}
-	if err, ok := e.(*httpError); ok {
-		return err.statusCode == code
+	if c := ErrStatusCode(e); c != 0 {
+		return c == code
 	}
 	return false
 }
@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// errorMapping maps the errors that match to a status code and public message.
+type errorMapping struct {
+	match      func(error) bool
+	statusCode int
+	message    string
+}
+
+// errorRegistry holds the mappings of domain errors to HTTP statuses, in the
+// order they were registered.
+type errorRegistry struct {
+	mu       sync.RWMutex
+	mappings []errorMapping
+}
+
+var registeredErrors = &errorRegistry{}
+
+func (r *errorRegistry) register(m errorMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = append(r.mappings, m)
+}
+
+func (r *errorRegistry) lookup(err error) (errorMapping, bool) {
+	if err == nil {
+		return errorMapping{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for m := range slices.Values(r.mappings) {
+		if m.match(err) {
+			return m, true
+		}
+	}
+	return errorMapping{}, false
+}
+
+// RegisterError maps errors that match target with errors.Is to a status code
+// and a public message, so domain packages can return sentinel errors like
+// sql.ErrNoRows and stay HTTP-agnostic. ErrStatusCode, ErrBody, the Is*
+// helpers and the renderers of HandleErrors consult the registered mappings
+// when the error doesn't carry a status itself. The first registered mapping
+// that matches wins.
+//
+// The message is sent to clients, also for server errors. When it is empty the
+// status text is used.
+func RegisterError(target error, statusCode int, message string) {
+	RegisterErrorFunc(func(err error) bool { return errors.Is(err, target) }, statusCode, message)
+}
+
+// RegisterErrorType maps errors with an error of type T in their chain, as
+// found by errors.As, to a status code and a public message. See RegisterError.
+func RegisterErrorType[T error](statusCode int, message string) {
+	RegisterErrorFunc(func(err error) bool {
+		var target T
+		return errors.As(err, &target)
+	}, statusCode, message)
+}
+
+// RegisterErrorFunc maps errors for which match returns true to a status code
+// and a public message. See RegisterError.
+func RegisterErrorFunc(match func(error) bool, statusCode int, message string) {
+	registeredErrors.register(errorMapping{match: match, statusCode: statusCode, message: message})
+}
@@ -0,0 +1,81 @@
+package middlewares_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/casualjim/middlewares"
+)
+
+var (
+	errWidgetNotFound = errors.New("widget: not found")
+	errWidgetStore    = errors.New("widget: store unavailable")
+	errWidgetUnmapped = errors.New("widget: unmapped")
+)
+
+type widgetValidationError struct {
+	field string
+}
+
+func (e *widgetValidationError) Error() string {
+	return "widget: invalid " + e.field
+}
+
+func init() {
+	RegisterError(errWidgetNotFound, http.StatusNotFound, "widget not found")
+	RegisterError(errWidgetStore, http.StatusServiceUnavailable, "try again later")
+	RegisterErrorType[*widgetValidationError](http.StatusUnprocessableEntity, "")
+	RegisterErrorFunc(func(err error) bool {
+		return strings.HasPrefix(err.Error(), "widget: quota")
+	}, http.StatusTooManyRequests, "quota exceeded")
+}
+
+func TestRegisteredErrors(t *testing.T) {
+	wrapped := fmt.Errorf("loading widget 42: %w", errWidgetNotFound)
+	assert.True(t, IsNotFound(wrapped))
+	assert.Equal(t, http.StatusNotFound, ErrStatusCode(wrapped))
+	assert.Equal(t, "widget not found", ErrBody(wrapped))
+
+	invalid := fmt.Errorf("saving: %w", &widgetValidationError{field: "name"})
+	assert.Equal(t, http.StatusUnprocessableEntity, ErrStatusCode(invalid))
+	assert.Empty(t, ErrBody(invalid))
+
+	quota := errors.New("widget: quota of 10 reached")
+	assert.Equal(t, http.StatusTooManyRequests, ErrStatusCode(quota))
+
+	assert.True(t, IsServerError(errors.Join(errWidgetUnmapped, errWidgetStore)))
+	assert.Zero(t, ErrStatusCode(errWidgetUnmapped))
+
+	// an explicit status takes precedence over the registry
+	explicit := Wrap(http.StatusGone, errWidgetNotFound, "widget deleted")
+	assert.Equal(t, http.StatusGone, ErrStatusCode(explicit))
+	assert.Equal(t, "widget deleted", ErrBody(explicit))
+}
+
+func TestHandleErrorsRegisteredErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		body string
+	}{
+		{fmt.Errorf("get: %w", errWidgetNotFound), `{"message":"widget not found","code":404}`},
+		{&widgetValidationError{field: "name"}, `{"message":"Unprocessable Entity","code":422}`},
+		{fmt.Errorf("get: %w", errWidgetStore), `{"message":"try again later","code":503}`},
+		{Wrap(http.StatusServiceUnavailable, errWidgetStore, "db is down"), `{"message":"Service Unavailable","code":503}`},
+	}
+
+	for tt := range slices.Values(tests) {
+		h := HandleErrors(nil, nil)(func(http.ResponseWriter, *http.Request) error {
+			return tt.err
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.JSONEq(t, tt.body, w.Body.String())
+	}
+}
@@ -0,0 +1,117 @@
+package middlewares
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+
+	"github.com/casualjim/middlewares/slogx"
+)
+
+// defaultErrorPage is the HTML template NegotiateErrors renders for browsers.
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+<p>{{.Message}}</p>
+{{- if .Fields}}
+<ul>
+{{- range .Fields}}
+<li><code>{{.Field}}</code>: {{if .Message}}{{.Message}}{{else}}{{.Code}}{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// ErrorPage is the data of the HTML template that renders errors.
+type ErrorPage struct {
+	Status  int
+	Title   string
+	Message string
+	Fields  []FieldError
+}
+
+// NegotiateErrorsOption configures the renderer created by NegotiateErrors.
+type NegotiateErrorsOption func(*negotiatedErrors)
+
+// ErrorHTMLTemplate sets the template that renders text/html errors, it is
+// executed with an ErrorPage.
+func ErrorHTMLTemplate(tmpl *template.Template) NegotiateErrorsOption {
+	return func(n *negotiatedErrors) {
+		n.html = tmpl
+	}
+}
+
+// ErrorFormat registers the renderer for a media type, replacing the renderer
+// of a built-in media type or adding one with the lowest preference.
+func ErrorFormat(mediaType string, render ErrorRenderer) NegotiateErrorsOption {
+	return func(n *negotiatedErrors) {
+		if !slices.Contains(n.offers, mediaType) {
+			n.offers = append(n.offers, mediaType)
+		}
+		n.renderers[mediaType] = render
+	}
+}
+
+type negotiatedErrors struct {
+	offers    []string
+	renderers map[string]ErrorRenderer
+	html      *template.Template
+}
+
+// NegotiateErrors creates an ErrorRenderer that picks the format of the error
+// response from the Accept header of the request: application/json renders
+// with RenderJSONError, application/problem+json with RenderProblem,
+// application/problem+xml with problem details in XML, text/plain with
+// TextError and text/html with an HTML page. JSON is used when the client
+// doesn't send an Accept header or accepts none of these.
+//
+// Use it with HandleErrors and RecoverErrors.
+func NegotiateErrors(opts ...NegotiateErrorsOption) ErrorRenderer {
+	n := &negotiatedErrors{
+		offers: []string{ContentTypeJSON, ContentTypeProblemJSON, ContentTypeProblemXML, "text/plain", "text/html"},
+		html:   defaultErrorPage,
+	}
+	n.renderers = map[string]ErrorRenderer{
+		ContentTypeJSON:        RenderJSONError,
+		ContentTypeProblemJSON: RenderProblem,
+		ContentTypeProblemXML: func(w http.ResponseWriter, _ *http.Request, err error) {
+			addErrHeader(w, err)
+			WriteProblemXML(w, ProblemFromError(err))
+		},
+		"text/plain": RenderMessage(TextError),
+		"text/html":  n.renderHTML,
+	}
+	for opt := range slices.Values(opts) {
+		opt(n)
+	}
+	return n.render
+}
+
+func (n *negotiatedErrors) render(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Add("Vary", "Accept")
+	mediaType, ok := negotiateContentType(r.Header.Values("Accept"), n.offers)
+	if !ok {
+		mediaType = n.offers[0]
+	}
+	n.renderers[mediaType](w, r, err)
+}
+
+func (n *negotiatedErrors) renderHTML(w http.ResponseWriter, r *http.Request, err error) {
+	code, msg := errorResponse(err)
+	addErrHeader(w, err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if err := n.html.Execute(w, ErrorPage{
+		Status:  code,
+		Title:   http.StatusText(code),
+		Message: msg,
+		Fields:  ErrFieldErrors(err),
+	}); err != nil {
+		requestLogger(r.Context(), nil).Error("write html error to response", slogx.Error(err))
+	}
+}
@@ -0,0 +1,145 @@
+package middlewares_test
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestNegotiateErrors(t *testing.T) {
+	err := ValidationError(http.StatusBadRequest, "invalid <input>", FieldError{Field: "name", Code: "required"})
+	render := NegotiateErrors()
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "no accept header",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"message":"invalid <input>","code":400,"errors":[{"field":"name","code":"required"}]}`,
+		},
+		{
+			name:        "json",
+			accept:      "application/json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"message":"invalid <input>","code":400,"errors":[{"field":"name","code":"required"}]}`,
+		},
+		{
+			name:        "problem json",
+			accept:      "application/problem+json, application/json;q=0.9",
+			contentType: ContentTypeProblemJSON,
+			body:        `{"title":"Bad Request","status":400,"detail":"invalid <input>","errors":[{"field":"name","code":"required"}]}`,
+		},
+		{
+			name:        "problem xml",
+			accept:      "application/problem+xml",
+			contentType: ContentTypeProblemXML,
+			body:        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<problem xmlns=\"urn:ietf:rfc:7807\"><title>Bad Request</title><status>400</status><detail>invalid &lt;input&gt;</detail><errors><i><code>required</code><field>name</field></i></errors></problem>",
+		},
+		{
+			name:        "plain text",
+			accept:      "text/plain",
+			contentType: "text/plain; charset=utf-8",
+			body:        "invalid <input>\n",
+		},
+		{
+			name:        "browser",
+			accept:      "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			contentType: "text/html; charset=utf-8",
+		},
+		{
+			name:        "not acceptable falls back to json",
+			accept:      "image/png",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"message":"invalid <input>","code":400,"errors":[{"field":"name","code":"required"}]}`,
+		},
+	}
+
+	for tt := range slices.Values(tests) {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			render(w, req, err)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			switch {
+			case tt.body == "":
+				assert.Contains(t, w.Body.String(), "<h1>400 Bad Request</h1>")
+				assert.Contains(t, w.Body.String(), "<p>invalid &lt;input&gt;</p>")
+				assert.Contains(t, w.Body.String(), "<li><code>name</code>: required</li>")
+			case tt.body[0] == '{':
+				assert.JSONEq(t, tt.body, w.Body.String())
+			default:
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNegotiateErrorsOptions(t *testing.T) {
+	render := NegotiateErrors(
+		ErrorHTMLTemplate(template.Must(template.New("error").Parse(`oops {{.Status}}`))),
+		ErrorFormat("application/vnd.api+json", func(w http.ResponseWriter, _ *http.Request, err error) {
+			w.WriteHeader(ErrStatusCode(err))
+			_, _ = w.Write([]byte("custom"))
+		}),
+	)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	render(w, req, Error(http.StatusNotFound, "gone"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "oops 404", w.Body.String())
+
+	req.Header.Set("Accept", "application/vnd.api+json")
+	w = httptest.NewRecorder()
+	render(w, req, Error(http.StatusNotFound, "gone"))
+	assert.Equal(t, "custom", w.Body.String())
+}
+
+func TestRecoverErrors(t *testing.T) {
+	h := RecoverErrors(nil, NegotiateErrors())(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			panic(Error(http.StatusBadRequest, "bad input"))
+		}
+		panic(errors.New("secret connection string"))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+
+	req = httptest.NewRequest("GET", "/bad", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"bad input","code":400}`, w.Body.String())
+
+	abort := RecoverErrors(nil, nil)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	w = httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		abort.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	})
+	assert.Zero(t, w.Body.Len())
+}
@@ -0,0 +1,119 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// maxErrorBodySize limits how much of an error response is read to decode it.
+const maxErrorBodySize = 1 << 20
+
+// errorResponseHeaders are the response headers that are kept on decoded errors.
+var errorResponseHeaders = []string{"Retry-After", "Www-Authenticate", "Allow"}
+
+// ErrorFromResponse turns a 4xx or 5xx response into an error that carries the
+// status code, so ErrStatusCode, IsNotFound and IsServerError work with it like
+// they do on the server. It returns nil for other responses.
+//
+// Bodies in the shape of JSONError, including the errors array of validation
+// errors, Connect errors and application/problem+json bodies are decoded, plain
+// text bodies are used as message and other bodies are ignored. The
+// Retry-After, WWW-Authenticate and Allow headers are kept on the error. The
+// body is read and replaced, so it can still be read by the caller.
+func ErrorFromResponse(resp *http.Response) error {
+	if resp == nil || resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	var body []byte
+	if resp.Body != nil && resp.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+		if err == nil {
+			body = b
+		}
+	}
+
+	var header http.Header
+	for k := range slices.Values(errorResponseHeaders) {
+		if v := resp.Header.Values(k); len(v) > 0 {
+			if header == nil {
+				header = make(http.Header)
+			}
+			header[k] = slices.Clone(v)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == ContentTypeProblemJSON:
+		p := new(ProblemDetails)
+		if err := json.Unmarshal(body, p); err == nil {
+			p.Status = resp.StatusCode
+			p.header = header
+			if fields, ok := p.Extensions["errors"]; ok {
+				// keep field errors recognizable by ErrFieldErrors
+				var fe []FieldError
+				if b, err := json.Marshal(fields); err == nil && json.Unmarshal(b, &fe) == nil {
+					p.Extensions["errors"] = fe
+				}
+			}
+			return p
+		}
+	case mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json"):
+		var msg struct {
+			Message string          `json:"message"`
+			Code    json.RawMessage `json:"code"`
+			Errors  []FieldError    `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &msg); err == nil && (msg.Message != "" || len(msg.Code) > 0) {
+			// the code is a number in JSONError and a name in Connect errors
+			var code Code
+			var name string
+			if json.Unmarshal(msg.Code, &name) == nil {
+				code, _ = ParseCode(name)
+			}
+			if msg.Message == "" {
+				msg.Message = http.StatusText(resp.StatusCode)
+			}
+			return &httpError{statusCode: resp.StatusCode, body: msg.Message, fields: msg.Errors, header: header, code: code}
+		}
+	}
+
+	msg := strings.TrimSpace(string(body))
+	if msg == "" || mediaType != "text/plain" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &httpError{statusCode: resp.StatusCode, body: msg, header: header}
+}
+
+// ErrorTransport decorates an existing transport so 4xx and 5xx responses are
+// returned as errors, decoded with ErrorFromResponse. http.Client wraps them in
+// a *url.Error, which supports errors.As, so ErrStatusCode and the Is* helpers
+// keep working on the error the client returns.
+func ErrorTransport(toWrap http.RoundTripper) http.RoundTripper {
+	return &errorTransport{w: toWrap}
+}
+
+type errorTransport struct {
+	w http.RoundTripper
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.w.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if err := ErrorFromResponse(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
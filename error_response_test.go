@@ -0,0 +1,130 @@
+package middlewares_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestErrorFromResponse(t *testing.T) {
+	validation := ValidationError(http.StatusUnprocessableEntity, "validation failed", FieldError{Field: "name", Code: "required"})
+
+	tests := []struct {
+		name   string
+		render ErrorRenderer
+		err    error
+		status int
+		body   string
+		fields []FieldError
+		header http.Header
+	}{
+		{
+			name:   "json error",
+			render: RenderJSONError,
+			err:    Error(http.StatusNotFound, "widget not found"),
+			status: http.StatusNotFound,
+			body:   "widget not found",
+		},
+		{
+			name:   "json validation error",
+			render: RenderJSONError,
+			err:    validation,
+			status: http.StatusUnprocessableEntity,
+			body:   "validation failed",
+			fields: []FieldError{{Field: "name", Code: "required"}},
+		},
+		{
+			name:   "problem validation error",
+			render: RenderProblem,
+			err:    validation,
+			status: http.StatusUnprocessableEntity,
+			body:   "validation failed",
+			fields: []FieldError{{Field: "name", Code: "required"}},
+		},
+		{
+			name:   "problem with headers",
+			render: RenderProblem,
+			err:    TooManyRequests(3 * time.Second),
+			status: http.StatusTooManyRequests,
+			body:   "Too Many Requests",
+			header: http.Header{"Retry-After": {"3"}},
+		},
+		{
+			name:   "plain text",
+			render: RenderMessage(TextError),
+			err:    Unauthorized("Basic"),
+			status: http.StatusUnauthorized,
+			body:   "Unauthorized",
+			header: http.Header{"Www-Authenticate": {"Basic"}},
+		},
+		{
+			name:   "server error",
+			render: RenderJSONError,
+			err:    errors.New("boom"),
+			status: http.StatusInternalServerError,
+			body:   "Internal Server Error",
+		},
+	}
+
+	for tt := range slices.Values(tests) {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.render(w, httptest.NewRequest("GET", "/", nil), tt.err)
+			resp := w.Result()
+
+			err := ErrorFromResponse(resp)
+			require.Error(t, err)
+			assert.Equal(t, tt.status, ErrStatusCode(err))
+			assert.Equal(t, tt.body, ErrBody(err))
+			assert.Equal(t, tt.fields, ErrFieldErrors(err))
+			assert.Equal(t, tt.header, ErrHeader(err))
+
+			// the body can still be read
+			b, rerr := io.ReadAll(resp.Body)
+			require.NoError(t, rerr)
+			assert.NotEmpty(t, b)
+		})
+	}
+
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{"Content-Type": {"text/html"}}, Body: io.NopCloser(strings.NewReader("<html>bad gateway</html>"))}
+	assert.Equal(t, "Bad Gateway", ErrBody(ErrorFromResponse(resp)))
+	assert.True(t, IsServerError(ErrorFromResponse(resp)))
+
+	assert.NoError(t, ErrorFromResponse(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}))
+	assert.NoError(t, ErrorFromResponse(&http.Response{StatusCode: http.StatusNotModified, Body: http.NoBody}))
+}
+
+func TestErrorTransport(t *testing.T) {
+	srv := httptest.NewServer(HandleErrors(nil, nil)(func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/missing" {
+			return Error(http.StatusNotFound, "widget not found")
+		}
+		_, _ = w.Write([]byte("ok"))
+		return nil
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: ErrorTransport(http.DefaultTransport)}
+
+	_, err := client.Get(srv.URL + "/missing")
+	require.Error(t, err)
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, "widget not found", ErrBody(err))
+
+	resp, err := client.Get(srv.URL + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(b))
+}
@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxStackDepth limits the number of frames captured for an error.
+const maxStackDepth = 32
+
+var captureErrorStacks atomic.Bool
+
+// CaptureErrorStacks enables or disables capturing the stack when a server
+// error is created with Error, Wrap and the other constructors of HTTP errors.
+// It is disabled by default, because capturing stacks isn't free.
+//
+// Captured stacks are exposed through slog.LogValuer, so logging the error with
+// slogx.Error includes the source and stack. They are never rendered in
+// responses.
+func CaptureErrorStacks(enabled bool) {
+	captureErrorStacks.Store(enabled)
+}
+
+// withStack captures the stack of the caller of the function that calls it,
+// skipping skip more frames, when e is a server error and capturing is enabled.
+func withStack(e *httpError, skip int) *httpError {
+	if e.statusCode < http.StatusInternalServerError || !captureErrorStacks.Load() {
+		return e
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	// skip runtime.Callers, withStack and the constructor
+	n := runtime.Callers(skip+2, pcs)
+	e.stack = pcs[:n]
+	return e
+}
+
+// LogValue logs the message, status, source and stack of errors with a
+// captured stack, and just the message of other errors.
+func (e *httpError) LogValue() slog.Value {
+	if len(e.stack) == 0 {
+		return slog.StringValue(e.Error())
+	}
+
+	frames := runtime.CallersFrames(e.stack)
+	var stack strings.Builder
+	var source string
+	for {
+		frame, more := frames.Next()
+		location := frame.File + ":" + strconv.Itoa(frame.Line)
+		if source == "" {
+			source = location
+		}
+		stack.WriteString(frame.Function)
+		stack.WriteString("\n\t")
+		stack.WriteString(location)
+		stack.WriteByte('\n')
+		if !more {
+			break
+		}
+	}
+
+	return slog.GroupValue(
+		slog.String("msg", e.Error()),
+		slog.Int("status", e.statusCode),
+		slog.String("source", source),
+		slog.String("stack", stack.String()),
+	)
+}
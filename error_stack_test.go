@@ -0,0 +1,67 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+	"github.com/casualjim/middlewares/slogx"
+)
+
+func TestCaptureErrorStacks(t *testing.T) {
+	CaptureErrorStacks(true)
+	t.Cleanup(func() { CaptureErrorStacks(false) })
+
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	err := fmt.Errorf("load: %w", Wrap(http.StatusBadGateway, errors.New("upstream closed connection"), ""))
+	lg.Error("failed", slogx.Error(err))
+
+	var entry struct {
+		Error struct {
+			Msg    string `json:"msg"`
+			Status int    `json:"status"`
+			Source string `json:"source"`
+			Stack  string `json:"stack"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "load: [502] Bad Gateway: upstream closed connection", entry.Error.Msg)
+	assert.Equal(t, http.StatusBadGateway, entry.Error.Status)
+	assert.Contains(t, entry.Error.Source, "error_stack_test.go:")
+	assert.Contains(t, entry.Error.Stack, "TestCaptureErrorStacks")
+	assert.NotContains(t, entry.Error.Stack, "withStack")
+
+	// the stack never reaches the response
+	logs.Reset()
+	h := HandleErrors(lg, NegotiateErrors())(func(http.ResponseWriter, *http.Request) error {
+		return ServiceUnavailable(0)
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.JSONEq(t, `{"message":"Service Unavailable","code":503}`, w.Body.String())
+	assert.Contains(t, logs.String(), "error_stack_test.go:")
+
+	// client errors don't capture stacks
+	logs.Reset()
+	lg.Error("failed", slogx.Error(Error(http.StatusNotFound, "missing")))
+	assert.Contains(t, logs.String(), `"error":"[404] missing"`)
+}
+
+func TestErrorStacksDisabled(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	lg.Error("failed", slogx.Error(Error(http.StatusInternalServerError, "boom")))
+	assert.Contains(t, logs.String(), `"error":"[500] boom"`)
+}
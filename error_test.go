@@ -1,8 +1,12 @@
 package middlewares_test
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"slices"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -63,3 +67,87 @@ func TestHTTPError(t *testing.T) {
 		})
 	}
 }
+
+func TestWrappedHTTPError(t *testing.T) {
+	cause := errors.New("sql: no rows in result set")
+	err := Wrap(http.StatusNotFound, cause, "user not found")
+
+	assert.Equal(t, "[404] user not found: sql: no rows in result set", err.Error())
+	assert.Equal(t, http.StatusNotFound, ErrStatusCode(err))
+	assert.Equal(t, "user not found", ErrBody(err))
+	assert.ErrorIs(t, err, cause)
+	assert.ErrorIs(t, err, Error(http.StatusNotFound, ""))
+	assert.NotErrorIs(t, err, Error(http.StatusBadRequest, ""))
+
+	annotated := fmt.Errorf("loading profile: %w", err)
+	assert.True(t, IsNotFound(annotated))
+	assert.Equal(t, "user not found", ErrBody(annotated))
+	assert.ErrorIs(t, annotated, cause)
+
+	joined := errors.Join(errors.New("other"), annotated)
+	assert.True(t, IsNotFound(joined))
+
+	// the outermost status wins
+	outer := Wrap(http.StatusServiceUnavailable, annotated, "")
+	assert.Equal(t, http.StatusServiceUnavailable, ErrStatusCode(outer))
+	assert.Equal(t, "Service Unavailable", ErrBody(outer))
+	assert.True(t, IsServerError(outer))
+
+	problem := fmt.Errorf("wrapped: %w", NewProblem(http.StatusConflict, "conflict"))
+	assert.Equal(t, http.StatusConflict, ErrStatusCode(problem))
+	assert.Equal(t, "conflict", ErrBody(problem))
+
+	assert.NoError(t, Wrap(http.StatusInternalServerError, nil, "boom"))
+	assert.Zero(t, ErrStatusCode(cause))
+	assert.Empty(t, ErrBody(cause))
+	assert.False(t, IsError(cause, 0))
+}
+
+func TestValidationError(t *testing.T) {
+	fields := []FieldError{
+		{Field: "name", Code: "required", Message: "name is required"},
+		{Field: "items[0].qty", Code: "min", Message: "must be at least 1"},
+	}
+	err := ValidationError(http.StatusUnprocessableEntity, "validation failed", fields...)
+
+	assert.True(t, IsUnprocessable(err))
+	assert.False(t, IsBadRequest(err))
+	assert.Equal(t, "validation failed", ErrBody(err))
+	assert.Equal(t, fields, ErrFieldErrors(fmt.Errorf("create order: %w", err)))
+
+	bad := ValidationError(http.StatusBadRequest, "invalid query", FieldError{Field: "limit", Code: "type"})
+	assert.True(t, IsBadRequest(bad))
+	assert.Len(t, ErrFieldErrors(bad), 1)
+
+	assert.Nil(t, ErrFieldErrors(Error(http.StatusBadRequest, "bad")))
+	assert.Nil(t, ErrFieldErrors(errors.New("plain")))
+	// the outermost HTTP error decides
+	assert.Nil(t, ErrFieldErrors(Wrap(http.StatusInternalServerError, err, "")))
+}
+
+func TestHeaderCarryingErrors(t *testing.T) {
+	err := TooManyRequests(1500 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, ErrStatusCode(err))
+	assert.Equal(t, "2", ErrHeader(err).Get("Retry-After"))
+
+	assert.Equal(t, "120", ErrHeader(ServiceUnavailable(2*time.Minute)).Get("Retry-After"))
+	assert.Nil(t, ErrHeader(ServiceUnavailable(0)))
+	assert.True(t, IsServerError(ServiceUnavailable(0)))
+
+	unauthorized := Unauthorized(`Bearer realm="api"`)
+	assert.True(t, IsUnauthorized(unauthorized))
+	assert.Equal(t, `Bearer realm="api"`, ErrHeader(unauthorized).Get("WWW-Authenticate"))
+	assert.Nil(t, ErrHeader(Unauthorized("")))
+	assert.Nil(t, ErrHeader(TooManyRequests(0)))
+
+	assert.Equal(t, "GET, HEAD", ErrHeader(MethodNotAllowed(http.MethodGet, http.MethodHead)).Get("Allow"))
+
+	// headers survive wrapping, outer errors take precedence
+	wrapped := Wrap(http.StatusServiceUnavailable, fmt.Errorf("quota: %w", err), "")
+	assert.Equal(t, "2", ErrHeader(wrapped).Get("Retry-After"))
+	outer := ErrorWithHeader(http.StatusTooManyRequests, "slow down", http.Header{"Retry-After": {"10"}, "X-Limit": {"5"}})
+	joined := errors.Join(fmt.Errorf("outer: %w", outer), err)
+	assert.Equal(t, http.Header{"Retry-After": {"10"}, "X-Limit": {"5"}}, ErrHeader(joined))
+
+	assert.Nil(t, ErrHeader(errors.New("plain")))
+}
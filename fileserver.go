@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// precompressedExtensions maps the content-codings to the file extension of
+// their pre-compressed siblings.
+var precompressedExtensions = map[string]string{
+	zstda: ".zst",
+	br:    ".br",
+	gzipa: ".gz",
+}
+
+// PrecompressedFileServer serves the files of fsys like http.FileServerFS, but
+// serves a pre-compressed sibling of the requested file when the client accepts
+// its encoding: app.js is served from app.js.zst, app.js.br or app.js.gz with
+// the matching Content-Encoding and the Content-Type of app.js.
+//
+// The encoding is negotiated like CompressHandlerWithOptions does, with the
+// encodings that have a sibling on disk. When no sibling is acceptable the file
+// is served by a http.FileServerFS wrapped in CompressHandlerWithOptions, so it
+// gets compressed on the fly. The options apply to both.
+//
+// A sibling is served like CompressHandlerWithOptions serves a compressed
+// response: without Accept-Ranges, ignoring ranges of the request, and with the
+// ETag set by an enclosing handler varied by the content-coding. Siblings of a
+// file that doesn't exist are not served.
+func PrecompressedFileServer(fsys fs.FS, opts ...CompressOption) http.Handler {
+	o := newCompressOptions(opts)
+	fallback := CompressHandlerWithOptions(http.FileServerFS(fsys), opts...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || (o.skip != nil && o.skip(r)) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		// http.FileServer redirects requests for index.html to the directory, it
+		// can keep doing that.
+		upath := r.URL.Path
+		if strings.HasSuffix(upath, "/index.html") {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasSuffix(upath, "/") {
+			upath += "index.html"
+		}
+		name := strings.TrimPrefix(path.Clean("/"+upath), "/")
+		if fi, err := fs.Stat(fsys, name); err != nil || !fi.Mode().IsRegular() {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		var available []string
+		for enc := range slices.Values(o.encodings) {
+			ext, ok := precompressedExtensions[enc]
+			if !ok {
+				continue
+			}
+			if fi, err := fs.Stat(fsys, name+ext); err == nil && fi.Mode().IsRegular() {
+				available = append(available, enc)
+			}
+		}
+		if len(available) == 0 {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		encoding, ok := negotiateEncoding(r.Header.Values("Accept-Encoding"), available)
+		if !ok || encoding == identity {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		f, err := fsys.Open(name + precompressedExtensions[encoding])
+		if err != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		rs, seekable := f.(io.ReadSeeker)
+		if err != nil || !seekable {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		var held []string
+		if o.weakETags {
+			held = weakETags(r)
+		} else {
+			r, held = stripETagSuffixes(r, encoding)
+		}
+		if r.Header.Get("Range") != "" {
+			// ranges would be over the encoded bytes.
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Header = r.Header.Clone()
+			r2.Header.Del("Range")
+			r = r2
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Accept-Encoding")
+		h.Set("Content-Encoding", encoding)
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", precompressedContentType(fsys, name))
+		}
+		http.ServeContent(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(whf httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					h.Del("Accept-Ranges")
+					if code != http.StatusNotModified || slices.Contains(held, h.Get("ETag")) {
+						varyETag(h, encoding, o.weakETags)
+					}
+					whf(code)
+				}
+			},
+		}), r, name, fi.ModTime(), rs)
+	})
+}
+
+// precompressedContentType determines the content type of the uncompressed file
+// from its extension, or its contents when the extension is unknown.
+func precompressedContentType(fsys fs.FS, name string) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrecompressedFileServer(t *testing.T) {
+	js := strings.Repeat("console.log('Gorilla!');\n", 100)
+	fsys := fstest.MapFS{
+		"app.js":         {Data: []byte(js)},
+		"app.js.gz":      {Data: encodeBody(t, gzipa, js)},
+		"app.js.br":      {Data: encodeBody(t, br, js)},
+		"style.css":      {Data: []byte(strings.Repeat("body { color: red; }\n", 100))},
+		"index.html":     {Data: []byte("<html></html>")},
+		"index.html.gz":  {Data: encodeBody(t, gzipa, "<html></html>")},
+		"noext":          {Data: []byte("plain text without an extension")},
+		"noext.gz":       {Data: encodeBody(t, gzipa, "plain text without an extension")},
+		"sub/lib.js.zst": {Data: encodeBody(t, zstda, js)},
+	}
+	h := PrecompressedFileServer(fsys)
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("brotli sibling", func(t *testing.T) {
+		w := get("/app.js", "gzip, br")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, br, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+		b, err := io.ReadAll(brotli.NewReader(w.Body))
+		require.NoError(t, err)
+		assert.Equal(t, js, string(b))
+	})
+
+	t.Run("gzip sibling", func(t *testing.T) {
+		w := get("/app.js", "gzip")
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, js, gunzip(t, w.Body))
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		w := get("/app.js", "")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, js, w.Body.String())
+	})
+
+	t.Run("on the fly", func(t *testing.T) {
+		w := get("/style.css", "gzip")
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, strings.Repeat("body { color: red; }\n", 100), gunzip(t, w.Body))
+	})
+
+	t.Run("no acceptable sibling", func(t *testing.T) {
+		w := get("/app.js", "deflate")
+		assert.Equal(t, deflate, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("directory index", func(t *testing.T) {
+		w := get("/", "gzip")
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "<html></html>", gunzip(t, w.Body))
+
+		w = get("/index.html", "gzip")
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	})
+
+	t.Run("sniffed content type", func(t *testing.T) {
+		w := get("/noext", "gzip")
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("sibling without original", func(t *testing.T) {
+		w := get("/sub/lib.js", "zstd")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = get("/sub/lib.js", "gzip")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestPrecompressedFileServerHeaders(t *testing.T) {
+	js := strings.Repeat("console.log('Gorilla!');\n", 100)
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte(js)},
+		"app.js.gz": {Data: encodeBody(t, gzipa, js)},
+	}
+	fileServer := PrecompressedFileServer(fsys)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fileServer.ServeHTTP(w, r)
+	})
+
+	do := func(method string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/app.js", nil)
+		r.Header = header
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("get", func(t *testing.T) {
+		w := do("GET", http.Header{"Range": []string{"bytes=0-8"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"v1-gzip"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, js, gunzip(t, w.Body))
+	})
+
+	t.Run("head", func(t *testing.T) {
+		w := do("HEAD", http.Header{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, `"v1-gzip"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Accept-Ranges"))
+		assert.Zero(t, w.Body.Len())
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		w := do("GET", http.Header{"If-None-Match": []string{`"v1-gzip"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"v1-gzip"`, w.Header().Get("ETag"))
+
+		w = do("GET", http.Header{"If-None-Match": []string{`"v1"`}})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	})
+}
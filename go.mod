@@ -3,6 +3,7 @@ module github.com/casualjim/middlewares
 go 1.24.2
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/felixge/httpsnoop v1.0.4
 	github.com/goccy/go-json v0.10.5
 	github.com/klauspost/compress v1.18.0
@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"github.com/casualjim/middlewares/slogx"
+	"github.com/felixge/httpsnoop"
+)
+
+// ErrorRenderer writes the error response for an error returned by a handler.
+type ErrorRenderer func(http.ResponseWriter, *http.Request, error)
+
+// errorResponse returns the status code and the public message of err. The
+// status defaults to 500, the message is safe to send to clients.
+func errorResponse(err error) (int, string) {
+	code := ErrStatusCode(err)
+	if code < http.StatusBadRequest {
+		code = http.StatusInternalServerError
+	}
+	return code, publicErrBody(err, code)
+}
+
+// RenderMessage creates an ErrorRenderer that renders the status code and the
+// public message of the error with a message renderer like JSONError,
+// ProblemJSONError or TextError. The headers carried by the error are added to
+// the response.
+func RenderMessage(render PanicRenderer) ErrorRenderer {
+	return func(w http.ResponseWriter, _ *http.Request, err error) {
+		code, msg := errorResponse(err)
+		addErrHeader(w, err)
+		render(w, msg, code)
+	}
+}
+
+// RenderJSONError renders the error in the shape of JSONError, with the field
+// errors of a ValidationError as an errors array.
+func RenderJSONError(w http.ResponseWriter, r *http.Request, err error) {
+	code, msg := errorResponse(err)
+	addErrHeader(w, err)
+	fields := ErrFieldErrors(err)
+	if len(fields) == 0 {
+		JSONError(w, msg, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(struct {
+		Message string       `json:"message"`
+		Code    int          `json:"code"`
+		Errors  []FieldError `json:"errors"`
+	}{msg, code, fields}); err != nil {
+		requestLogger(r.Context(), nil).Error("write json error to response", slogx.Error(err))
+	}
+}
+
+// RenderProblem renders the error as application/problem+json, see
+// ProblemFromError.
+func RenderProblem(w http.ResponseWriter, _ *http.Request, err error) {
+	addErrHeader(w, err)
+	WriteProblem(w, ProblemFromError(err))
+}
+
+// HandlerFunc is an HTTP handler that returns an error instead of writing the
+// error response itself. As an http.Handler it renders returned errors with
+// RenderJSONError, use HandleErrors for another renderer or logger.
+type HandlerFunc func(http.ResponseWriter, *http.Request) error
+
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveWithErrors(nil, RenderJSONError, f, w, r)
+}
+
+// HandleErrors adapts a HandlerFunc to an http.Handler that renders the returned
+// errors with the renderer, RenderJSONError when nil.
+//
+// The status code is taken from the error with ErrStatusCode, so it survives
+// wrapping, and defaults to 500. The message is the body of the error for client
+// errors. Server errors are rendered with the status text, or the message
+// registered with RegisterError, so internal details don't leak, and are logged
+// with the request method and uri. When the handler wrote a response before
+// returning the error, the error is only logged.
+func HandleErrors(lg *slog.Logger, render ErrorRenderer) func(HandlerFunc) http.Handler {
+	if render == nil {
+		render = RenderJSONError
+	}
+	return func(next HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveWithErrors(lg, render, next, w, r)
+		})
+	}
+}
+
+func serveWithErrors(lg *slog.Logger, render ErrorRenderer, next HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	var written bool
+	nextw := httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(whf httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				// informational responses can still be followed by the error
+				if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+					written = true
+				}
+				whf(code)
+			}
+		},
+		Write: func(wf httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				written = true
+				return wf(b)
+			}
+		},
+		ReadFrom: func(rff httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				written = true
+				return rff(src)
+			}
+		},
+	})
+
+	err := next(nextw, r)
+	if err == nil {
+		return
+	}
+
+	if code, _ := errorResponse(err); code >= http.StatusInternalServerError || written {
+		attrs := append([]slog.Attr{slogx.Error(err), slog.Int("status", code)}, requestAttrs(r, lg)...)
+		requestLogger(r.Context(), lg).LogAttrs(r.Context(), slog.LevelError, "handling request "+r.Method+" "+r.RequestURI, attrs...)
+	}
+	if written {
+		return
+	}
+	render(w, r, err)
+}
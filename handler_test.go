@@ -0,0 +1,200 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestHandlerFunc(t *testing.T) {
+	h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/missing" {
+			return fmt.Errorf("lookup: %w", Error(http.StatusNotFound, "widget not found"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"message":"widget not found","code":404}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandleErrors(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	tests := []struct {
+		name     string
+		err      error
+		render   ErrorRenderer
+		status   int
+		body     string
+		logged   bool
+		ctHeader string
+	}{
+		{
+			name:     "client error",
+			err:      Error(http.StatusBadRequest, "invalid name"),
+			status:   http.StatusBadRequest,
+			body:     `{"message":"invalid name","code":400}`,
+			ctHeader: "application/json; charset=utf-8",
+		},
+		{
+			name:     "plain error hides details",
+			err:      errors.New("dial tcp 10.0.0.1:5432: connection refused"),
+			status:   http.StatusInternalServerError,
+			body:     `{"message":"Internal Server Error","code":500}`,
+			logged:   true,
+			ctHeader: "application/json; charset=utf-8",
+		},
+		{
+			name:     "server error hides details",
+			err:      Wrap(http.StatusBadGateway, errors.New("upstream timeout"), "upstream said no"),
+			status:   http.StatusBadGateway,
+			body:     `{"message":"Bad Gateway","code":502}`,
+			logged:   true,
+			ctHeader: "application/json; charset=utf-8",
+		},
+		{
+			name:     "problem renderer",
+			err:      Error(http.StatusConflict, "already exists"),
+			render:   RenderProblem,
+			status:   http.StatusConflict,
+			body:     `{"title":"Conflict","status":409,"detail":"already exists"}`,
+			ctHeader: ContentTypeProblemJSON,
+		},
+		{
+			name:     "text renderer",
+			err:      Error(http.StatusForbidden, ""),
+			render:   RenderMessage(TextError),
+			status:   http.StatusForbidden,
+			body:     "Forbidden\n",
+			ctHeader: "text/plain; charset=utf-8",
+		},
+	}
+
+	for tt := range slices.Values(tests) {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			h := HandleErrors(lg, tt.render)(func(http.ResponseWriter, *http.Request) error {
+				return tt.err
+			})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/widgets", nil))
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.ctHeader, w.Header().Get("Content-Type"))
+			if tt.ctHeader == "text/plain; charset=utf-8" {
+				assert.Equal(t, tt.body, w.Body.String())
+			} else {
+				assert.JSONEq(t, tt.body, w.Body.String())
+			}
+			if tt.logged {
+				assert.Contains(t, logs.String(), `"uri":"/widgets"`)
+				assert.Contains(t, logs.String(), `"method":"POST"`)
+				assert.Contains(t, logs.String(), tt.err.Error())
+			} else {
+				assert.Empty(t, logs.String())
+			}
+		})
+	}
+}
+
+func TestHandleErrorsAfterWrite(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := HandleErrors(lg, nil)(func(w http.ResponseWriter, _ *http.Request) error {
+		_, _ = w.Write([]byte("partial"))
+		return Error(http.StatusBadRequest, "too late")
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "partial", w.Body.String())
+	assert.Contains(t, logs.String(), "too late")
+}
+
+func TestRenderValidationError(t *testing.T) {
+	err := ValidationError(http.StatusUnprocessableEntity, "validation failed",
+		FieldError{Field: "name", Code: "required", Message: "name is required"},
+		FieldError{Field: "email", Code: "format"},
+	)
+	handler := func(http.ResponseWriter, *http.Request) error { return err }
+
+	w := httptest.NewRecorder()
+	HandlerFunc(handler).ServeHTTP(w, httptest.NewRequest("POST", "/users", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{
+		"message": "validation failed",
+		"code": 422,
+		"errors": [
+			{"field": "name", "code": "required", "message": "name is required"},
+			{"field": "email", "code": "format"}
+		]
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	HandleErrors(nil, RenderProblem)(handler).ServeHTTP(w, httptest.NewRequest("POST", "/users", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ContentTypeProblemJSON, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"title": "Unprocessable Entity",
+		"status": 422,
+		"detail": "validation failed",
+		"errors": [
+			{"field": "name", "code": "required", "message": "name is required"},
+			{"field": "email", "code": "format"}
+		]
+	}`, w.Body.String())
+}
+
+func TestProblemFromError(t *testing.T) {
+	p := NewProblem(http.StatusConflict, "version mismatch")
+	p.Type = "https://example.com/probs/version"
+	assert.Same(t, p, ProblemFromError(fmt.Errorf("update: %w", p)))
+
+	converted := ProblemFromError(errors.New("boom"))
+	assert.Equal(t, http.StatusInternalServerError, converted.Status)
+	assert.Equal(t, "Internal Server Error", converted.Detail)
+	assert.Nil(t, converted.Extensions)
+}
+
+func TestRenderErrorHeaders(t *testing.T) {
+	renderers := map[string]ErrorRenderer{
+		"json":       RenderJSONError,
+		"problem":    RenderProblem,
+		"text":       RenderMessage(TextError),
+		"negotiated": NegotiateErrors(),
+	}
+	for name, render := range renderers {
+		t.Run(name, func(t *testing.T) {
+			h := HandleErrors(nil, render)(func(http.ResponseWriter, *http.Request) error {
+				return fmt.Errorf("rate limit: %w", TooManyRequests(30*time.Second))
+			})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		})
+	}
+}
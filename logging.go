@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/casualjim/middlewares/slogx"
@@ -17,13 +19,46 @@ import (
 type (
 	contextRequestStartT struct{}
 	contextHandlerStartT struct{}
+	contextRouteT        struct{}
+	contextRequestAttrsT struct{}
 )
 
 var (
 	contextRequestStart contextRequestStartT
 	contextHandlerStart contextHandlerStartT
+	contextRoute        contextRouteT
+	contextRequestAttrs contextRequestAttrsT
 )
 
+// route holds the pattern an http.ServeMux matched for a request. The mux sets
+// the pattern on the request it receives, which is a copy when a middleware
+// changed the request on the way, so the middlewares of this package report it
+// back through the context after serving the copy.
+type route struct {
+	pattern string
+}
+
+// withRoute returns r with a route holder in its context, reusing the one of an
+// enclosing LogMiddleware.
+func withRoute(r *http.Request) (*http.Request, *route) {
+	if rt, ok := r.Context().Value(contextRoute).(*route); ok {
+		return r, rt
+	}
+	rt := new(route)
+	return r.WithContext(context.WithValue(r.Context(), contextRoute, rt)), rt
+}
+
+// reportRoute records the pattern matched for r, a copy of the request a
+// middleware was called with, in the route holder of its context.
+func reportRoute(r *http.Request) {
+	if r.Pattern == "" {
+		return
+	}
+	if rt, ok := r.Context().Value(contextRoute).(*route); ok {
+		rt.pattern = r.Pattern
+	}
+}
+
 func AlwaysDumpRequestBody() func(*loggingTransport) {
 	return func(t *loggingTransport) {
 		t.dumpRequestBody = func(*http.Request) bool { return true }
@@ -60,10 +95,19 @@ func FilteredDumpResponseBody(filter func(*http.Request, *http.Response) bool) f
 	}
 }
 
+// RedactDumps sets the Redactor for the request and response dumps,
+// DefaultRedactor by default. A nil Redactor logs the dumps verbatim.
+func RedactDumps(r *Redactor) func(*loggingTransport) {
+	return func(t *loggingTransport) {
+		t.redactor = r
+	}
+}
+
 // LoggingTransport decorates an existing transport with logging of request and responses
 func LoggingTransport(toWrap http.RoundTripper, opts ...func(*loggingTransport)) http.RoundTripper {
 	tr := &loggingTransport{
 		w:               toWrap,
+		redactor:        DefaultRedactor,
 		dumpRequestBody: func(*http.Request) bool { return false },
 		dumpResponseBody: func(*http.Request, *http.Response) bool {
 			return false
@@ -78,6 +122,7 @@ func LoggingTransport(toWrap http.RoundTripper, opts ...func(*loggingTransport))
 func LoggingTransportDebug(toWrap http.RoundTripper, opts ...func(*loggingTransport)) http.RoundTripper {
 	tr := &loggingTransport{
 		w:                toWrap,
+		redactor:         DefaultRedactor,
 		dumpRequestBody:  func(*http.Request) bool { return true },
 		dumpResponseBody: func(*http.Request, *http.Response) bool { return true },
 	}
@@ -91,6 +136,7 @@ func LoggingTransportDebug(toWrap http.RoundTripper, opts ...func(*loggingTransp
 
 type loggingTransport struct {
 	w                http.RoundTripper
+	redactor         *Redactor
 	dumpRequestBody  func(*http.Request) bool
 	dumpResponseBody func(*http.Request, *http.Response) bool
 }
@@ -98,8 +144,13 @@ type loggingTransport struct {
 func (l *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := context.WithValue(req.Context(), contextRequestStart, time.Now())
 
-	lg := slog.With("loggerName", "http.client", "method", req.Method, "uri", req.URL.String())
+	base := requestLogger(ctx, nil)
+	lg := base.With("loggerName", "http.client", "method", req.Method, "uri", req.URL.String())
 	req = req.WithContext(ctx)
+	if rid, ok := ctx.Value(contextRequestID).(requestID); ok && req.Header.Get(rid.header) == "" {
+		req.Header = req.Header.Clone()
+		req.Header.Set(rid.header, rid.id)
+	}
 
 	b, err := httputil.DumpRequest(req, l.dumpRequestBody(req))
 	if err != nil {
@@ -107,7 +158,7 @@ func (l *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	}
 
 	lg.Info("request " + req.Method + " " + req.URL.String())
-	slog.Debug("request:\n" + string(b))
+	base.Debug("request:\n" + string(l.redactor.Dump(b)))
 
 	resp, err := l.w.RoundTrip(req)
 	if err != nil {
@@ -124,25 +175,39 @@ func (l *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	} else {
 		lg.Info("response "+req.Method+" "+req.URL.String(), "status", resp.StatusCode)
 	}
-	slog.Debug("response:\n" + string(b))
+	base.Debug("response:\n" + string(l.redactor.Dump(b)))
 
 	return resp, err
 }
 
-// DebugDumpMiddleware that logs the request and responses.
+// DebugDumpMiddleware that logs the request and responses, with the secrets
+// known to DefaultRedactor redacted.
 func DebugDumpMiddleware(next http.Handler) http.Handler {
+	return DebugDumpRedacted(DefaultRedactor)(next)
+}
+
+// DebugDumpRedacted creates a DebugDumpMiddleware that redacts the logged
+// headers and bodies with the Redactor, a nil Redactor logs them verbatim.
+func DebugDumpRedacted(redactor *Redactor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return debugDump(redactor, next)
+	}
+}
+
+func debugDump(redactor *Redactor, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		ctx := context.WithValue(r.Context(), contextRequestStart, time.Now())
 		r = r.WithContext(ctx)
+		lg := requestLogger(ctx, nil)
 
 		b, err := httputil.DumpRequest(r, true)
 		if err != nil {
-			slog.Error("dumping request for debug", slogx.Error(err))
+			lg.Error("dumping request for debug", slogx.Error(err))
 			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 		reqMsg := fmt.Sprintf("request %s %s", r.Method, r.RequestURI)
-		slog.Info(reqMsg, slog.String("method", r.Method), slog.String("uri", r.RequestURI), slog.Any("headers", r.Header), slogx.ByteString("body", b))
+		lg.Info(reqMsg, slog.String("method", r.Method), slog.String("uri", r.RequestURI), slog.Any("headers", redactor.Header(r.Header)), slogx.ByteString("body", redactor.Dump(b)))
 
 		var statusCode int
 		body := bytes.NewBuffer(nil)
@@ -162,130 +227,288 @@ func DebugDumpMiddleware(next http.Handler) http.Handler {
 		})
 
 		next.ServeHTTP(nextw, r)
+		reportRoute(r)
 
 		respMsg := fmt.Sprintf("response [%d] %s %s", statusCode, r.Method, r.RequestURI)
 		if start, ok := ctx.Value(contextRequestStart).(time.Time); ok {
-			slog.Info(respMsg, slog.Int("status", statusCode), slog.String("uri", r.RequestURI), slog.Duration("elapsed", time.Since(start)), slog.Any("headers", rw.Header()), slogx.Stringer("body", body))
+			lg.Info(respMsg, slog.Int("status", statusCode), slog.String("uri", r.RequestURI), slog.Duration("elapsed", time.Since(start)), slog.Any("headers", redactor.Header(rw.Header())), slogx.ByteString("body", redactor.Body(body.Bytes())))
 		} else {
-			slog.Info(respMsg, slog.Int("status", statusCode), slog.String("uri", r.RequestURI), slog.Any("headers", rw.Header()), slog.String("body", body.String()))
+			lg.Info(respMsg, slog.Int("status", statusCode), slog.String("uri", r.RequestURI), slog.Any("headers", redactor.Header(rw.Header())), slogx.ByteString("body", redactor.Body(body.Bytes())))
 		}
 	})
 }
 
-// // LogMiddleware that logs the request and responses.
-// func LogMiddleware(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-// 		lg := zlog.Ctx(r.Context()).With(slog.String("method", r.Method), slog.String("url", r.RequestURI), slog.String("remote", r.RemoteAddr))
-// 		ctx := context.WithValue(r.Context(), contextHandlerStart, time.Now())
-
-// 		lg = captureIPCFields(ctx, r, lg)
-// 		r = r.WithContext(ctx)
-
-// 		reqMsg := fmt.Sprintf("request %s %s", r.Method, r.RequestURI)
-// 		lg.Debug(reqMsg)
-
-// 		statusCode := http.StatusOK
-// 		contentLength := int64(0)
-// 		nextw := httpsnoop.Wrap(rw, httpsnoop.Hooks{
-// 			WriteHeader: func(whf httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-// 				return func(code int) {
-// 					statusCode = code
-// 					whf(code)
-// 				}
-// 			},
-// 			Write: func(wf httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-// 				return func(b []byte) (int, error) {
-// 					n, err := wf(b)
-// 					contentLength += int64(n)
-// 					return n, err
-// 				}
-// 			},
-// 		})
-
-// 		next.ServeHTTP(nextw, r)
-// 		fields := []slog.Field{slog.Int("status", statusCode)}
-// 		if start, ok := ctx.Value(contextHandlerStart).(time.Time); ok {
-// 			fields = append(fields, slog.Duration("elapsed", time.Since(start)), slog.Int64("contentLength", contentLength))
-// 		}
-// 		respMsg := fmt.Sprintf("response [%d] %s %s", statusCode, r.Method, r.RequestURI)
-// 		lg.Info(respMsg, fields...)
-// 	})
-// }
-
-// // LogDumpErrorsMiddleware that logs the request and responses.
-// // This is a hybrid between the LogMiddleware and DebugDumpMiddleware
-// // It logs the request and response for all requests, but only logs the response body for requests that return an error.
-// func LogDumpErrorsMiddleware(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-// 		ctx := context.WithValue(r.Context(), contextRequestStart, time.Now())
-// 		lg := zlog.Ctx(ctx).With(slog.String("method", r.Method), slog.String("url", r.RequestURI), slog.String("remote", r.RemoteAddr))
-// 		lg = captureIPCFields(ctx, r, lg)
-// 		r = r.WithContext(ctx)
-
-// 		reqMsg := fmt.Sprintf("request %s %s", r.Method, r.RequestURI)
-// 		lg.Debug(reqMsg)
-
-// 		var statusCode int
-// 		contentLength := int64(0)
-// 		var body *bytes.Buffer
-// 		defer func() {
-// 			if body != nil {
-// 				putBuffer(body)
-// 			}
-// 		}()
-
-// 		nextw := httpsnoop.Wrap(rw, httpsnoop.Hooks{
-// 			WriteHeader: func(whf httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-// 				return func(code int) {
-// 					statusCode = code
-// 					whf(code)
-// 				}
-// 			},
-// 			Write: func(wf httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-// 				return func(b []byte) (int, error) {
-// 					if statusCode >= 400 && statusCode < 600 {
-// 						if body == nil {
-// 							body = getBuffer()
-// 						}
-// 						_, _ = body.Write(b)
-// 					}
-// 					n, err := wf(b)
-// 					contentLength += int64(n)
-// 					return n, err
-// 				}
-// 			},
-// 		})
-
-// 		next.ServeHTTP(nextw, r)
-
-// 		fields := []slog.Field{slog.Int("status", statusCode)}
-// 		if statusCode >= 400 && statusCode < 600 {
-// 			if start, ok := ctx.Value(contextHandlerStart).(time.Time); ok {
-// 				fields = append(fields, slog.Duration("elapsed", time.Since(start)))
-// 			}
-// 			fields = append(fields, slog.String("body", body.String()))
-// 		} else {
-// 			if start, ok := ctx.Value(contextHandlerStart).(time.Time); ok {
-// 				fields = append(fields, slog.Duration("elapsed", time.Since(start)))
-// 			}
-// 			fields = append(fields, slog.Int64("contentLength", contentLength))
-// 		}
-// 		respMsg := fmt.Sprintf("response [%d] %s %s", statusCode, r.Method, r.RequestURI)
-// 		lg.Info(respMsg, fields...)
-// 	})
-// }
-
-// var defaultPool = &sync.Pool{
-// 	New: func() interface{} {
-// 		return bytes.NewBuffer(nil)
-// 	},
-// }
-
-// func getBuffer() *bytes.Buffer {
-// 	return defaultPool.Get().(*bytes.Buffer)
-// }
-
-// func putBuffer(b *bytes.Buffer) {
-// 	b.Reset()
-// 	defaultPool.Put(b)
-// }
+// ContextLogger stores a logger for the request in its context, available
+// through slogx.FromContext, with the method, uri and remote address of the
+// request and the id assigned by RequestID as attributes. The middlewares of
+// this package log with it unless they are given a logger explicitly, and then
+// leave out the request attributes it has already. The slog.Default logger is
+// used when lg is nil.
+func ContextLogger(lg *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			base := lg
+			if base == nil {
+				base = slog.Default()
+			}
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("uri", r.RequestURI),
+				slog.String("remote", r.RemoteAddr),
+			}
+			if id := RequestIDFromContext(r.Context()); id != "" {
+				attrs = append(attrs, slog.String("request_id", id))
+			}
+			ctx := slogx.WithContext(r.Context(), base.With(attrs...))
+			ctx = context.WithValue(ctx, contextRequestAttrs, true)
+			r2 := r.WithContext(ctx)
+			next.ServeHTTP(rw, r2)
+			reportRoute(r2)
+		})
+	}
+}
+
+// requestLogger returns the logger to log about a request with: lg with the
+// request id as attribute, or the context logger when lg is nil.
+func requestLogger(ctx context.Context, lg *slog.Logger) *slog.Logger {
+	if lg == nil {
+		return slogx.FromContext(ctx)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		return lg.With(slog.String("request_id", id))
+	}
+	return lg
+}
+
+// requestAttrs returns the method, uri and remote address of r as attributes
+// for logging with requestLogger(r.Context(), lg). It returns nothing when that
+// is the context logger of ContextLogger, which has them already.
+func requestAttrs(r *http.Request, lg *slog.Logger) []slog.Attr {
+	if lg == nil && r.Context().Value(contextRequestAttrs) != nil {
+		return nil
+	}
+	return []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("uri", r.RequestURI),
+		slog.String("remote", r.RemoteAddr),
+	}
+}
+
+// LogOption configures the middleware created by LogMiddleware.
+type LogOption func(*logOptions)
+
+type logOptions struct {
+	level        func(int) slog.Level
+	dumpMaxBytes int
+	redactor     *Redactor
+}
+
+// LogLevels sets the level that responses are logged at by their status code,
+// by default 5xx responses are logged as errors, 4xx responses as warnings and
+// the others as info.
+func LogLevels(level func(statusCode int) slog.Level) LogOption {
+	return func(o *logOptions) {
+		o.level = level
+	}
+}
+
+func defaultLogLevel(statusCode int) slog.Level {
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		return slog.LevelError
+	case statusCode >= http.StatusBadRequest:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// LogMiddleware logs a line for every request with the method, route pattern,
+// uri, status, bytes written, duration, remote address and user agent. The
+// route is the pattern matched by an http.ServeMux that handled the request,
+// also when other middlewares of this package sit in between.
+// The context logger is used when lg is nil, see ContextLogger.
+func LogMiddleware(lg *slog.Logger, opts ...LogOption) func(http.Handler) http.Handler {
+	o := &logOptions{
+		level:    defaultLogLevel,
+		redactor: DefaultRedactor,
+	}
+	for opt := range slices.Values(opts) {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, rt := withRoute(r)
+
+			dump := o.dumpMaxBytes > 0
+			var reqBody, respBody boundedBuffer
+			if dump {
+				reqBody.max, respBody.max = o.dumpMaxBytes, o.dumpMaxBytes
+				defer reqBody.release()
+				defer respBody.release()
+				if r.Body != nil && r.Body != http.NoBody {
+					r2 := new(http.Request)
+					*r2 = *r
+					r2.Body = &teeBody{ReadCloser: r.Body, tee: io.TeeReader(r.Body, &reqBody)}
+					r = r2
+				}
+			}
+
+			var statusCode int
+			var written int64
+			capture := func() bool {
+				return dump && statusCode >= http.StatusBadRequest
+			}
+			nextw := httpsnoop.Wrap(rw, httpsnoop.Hooks{
+				WriteHeader: func(whf httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						if statusCode == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+							statusCode = code
+						}
+						whf(code)
+					}
+				},
+				Write: func(wf httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						if statusCode == 0 {
+							statusCode = http.StatusOK
+						}
+						n, err := wf(b)
+						if capture() {
+							_, _ = respBody.Write(b[:n])
+						}
+						written += int64(n)
+						return n, err
+					}
+				},
+				ReadFrom: func(rff httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						if statusCode == 0 {
+							statusCode = http.StatusOK
+						}
+						if capture() {
+							src = io.TeeReader(src, &respBody)
+						}
+						n, err := rff(src)
+						written += n
+						return n, err
+					}
+				},
+			})
+
+			next.ServeHTTP(nextw, r)
+
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+			reportRoute(r)
+			attrs := append(requestAttrs(r, lg),
+				slog.String("route", rt.pattern),
+				slog.Int("status", statusCode),
+				slog.Int64("bytes", written),
+				slog.Duration("elapsed", time.Since(start)),
+				slog.String("user_agent", r.UserAgent()),
+			)
+			if capture() {
+				attrs = append(attrs,
+					slogx.ByteString("request_body", o.redactor.Body(reqBody.Bytes())),
+					slog.Bool("request_body_truncated", reqBody.truncated),
+					slogx.ByteString("response_body", o.redactor.Body(respBody.Bytes())),
+					slog.Bool("response_body_truncated", respBody.truncated),
+				)
+			}
+
+			requestLogger(r.Context(), lg).LogAttrs(r.Context(), o.level(statusCode), fmt.Sprintf("response [%d] %s %s", statusCode, r.Method, r.RequestURI), attrs...)
+		})
+	}
+}
+
+// defaultDumpMaxBytes is the default limit on the bytes of a request or
+// response body that LogDumpErrorsMiddleware captures.
+const defaultDumpMaxBytes = 64 << 10
+
+var bufferPool = &sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(nil)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// boundedBuffer captures up to max bytes of what is written to it, and discards
+// the rest. Writes never fail, so it can be used with io.TeeReader.
+type boundedBuffer struct {
+	buf       *bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if b.buf == nil {
+		b.buf = getBuffer()
+	}
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *boundedBuffer) Bytes() []byte {
+	if b.buf == nil {
+		return nil
+	}
+	return b.buf.Bytes()
+}
+
+func (b *boundedBuffer) release() {
+	if b.buf != nil {
+		putBuffer(b.buf)
+		b.buf = nil
+	}
+}
+
+// teeBody copies what is read from the request body to a boundedBuffer.
+type teeBody struct {
+	io.ReadCloser
+	tee io.Reader
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	return t.tee.Read(p)
+}
+
+// LogRedactor sets the Redactor for the bodies logged with DumpBodiesOnError,
+// DefaultRedactor by default. A nil Redactor logs them verbatim.
+func LogRedactor(r *Redactor) LogOption {
+	return func(o *logOptions) {
+		o.redactor = r
+	}
+}
+
+// DumpBodiesOnError makes LogMiddleware log the request and response bodies of
+// 4xx and 5xx responses, up to maxBytes of each. The request body is captured
+// while the handler reads it, so only what the handler read gets logged.
+func DumpBodiesOnError(maxBytes int) LogOption {
+	return func(o *logOptions) {
+		o.dumpMaxBytes = maxBytes
+	}
+}
+
+// LogDumpErrorsMiddleware is a LogMiddleware that also logs the request and
+// response bodies, up to 64KB of each, of requests that failed with a 4xx or
+// 5xx response. It's a hybrid between LogMiddleware and DebugDumpMiddleware.
+func LogDumpErrorsMiddleware(lg *slog.Logger, opts ...LogOption) func(http.Handler) http.Handler {
+	return LogMiddleware(lg, append([]LogOption{DumpBodiesOnError(defaultDumpMaxBytes)}, opts...)...)
+}
@@ -0,0 +1,271 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+	"github.com/casualjim/middlewares/slogx"
+)
+
+type accessLogEntry struct {
+	Level     string `json:"level"`
+	Msg       string `json:"msg"`
+	Method    string `json:"method"`
+	Route     string `json:"route"`
+	URI       string `json:"uri"`
+	Status    int    `json:"status"`
+	Bytes     int64  `json:"bytes"`
+	Elapsed   int64  `json:"elapsed"`
+	Remote    string `json:"remote"`
+	UserAgent string `json:"user_agent"`
+}
+
+func TestLogMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			JSONError(w, "no such widget", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("widget " + r.PathValue("id")))
+	})
+	mux.HandleFunc("GET /boom", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	h := LogMiddleware(lg)(mux)
+
+	tests := []struct {
+		path   string
+		level  string
+		route  string
+		status int
+		bytes  int64
+	}{
+		{path: "/widgets/42", level: "INFO", route: "GET /widgets/{id}", status: http.StatusOK, bytes: 9},
+		{path: "/widgets/0", level: "WARN", route: "GET /widgets/{id}", status: http.StatusNotFound, bytes: 39},
+		{path: "/boom", level: "ERROR", route: "GET /boom", status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("User-Agent", "test-agent/1.0")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry accessLogEntry
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, tt.level, entry.Level)
+			assert.Equal(t, "GET", entry.Method)
+			assert.Equal(t, tt.route, entry.Route)
+			assert.Equal(t, tt.path, entry.URI)
+			assert.Equal(t, tt.status, entry.Status)
+			assert.Equal(t, tt.bytes, entry.Bytes)
+			assert.Equal(t, "192.0.2.1:1234", entry.Remote)
+			assert.Equal(t, "test-agent/1.0", entry.UserAgent)
+			assert.GreaterOrEqual(t, entry.Elapsed, int64(0))
+		})
+	}
+}
+
+func TestLogMiddlewareRoute(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("widget " + r.PathValue("id")))
+	})
+
+	tests := map[string]http.Handler{
+		"compress":   CompressHandler(mux),
+		"request id": RequestID()(mux),
+		"context":    ContextLogger(lg)(mux),
+		"chain":      RequestID()(ContextLogger(lg)(CompressHandler(DecompressRequest(mux)))),
+	}
+	for name, h := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs.Reset()
+			LogMiddleware(lg)(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/widgets/42", nil))
+
+			var entry accessLogEntry
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, "GET /widgets/{id}", entry.Route)
+		})
+	}
+}
+
+func TestLogMiddlewareLevels(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	h := LogMiddleware(lg, LogLevels(func(status int) slog.Level {
+		if status < http.StatusBadRequest {
+			return slog.LevelDebug
+		}
+		return slog.LevelError
+	}))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var entry accessLogEntry
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "DEBUG", entry.Level)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, "response [200] GET /", entry.Msg)
+}
+
+type dumpLogEntry struct {
+	Status                int    `json:"status"`
+	RequestBody           string `json:"request_body"`
+	RequestBodyTruncated  bool   `json:"request_body_truncated"`
+	ResponseBody          string `json:"response_body"`
+	ResponseBodyTruncated bool   `json:"response_body_truncated"`
+}
+
+func TestLogDumpErrorsMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := LogDumpErrorsMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) == `{"name":""}` {
+			JSONError(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("created"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"name":""}`)))
+	var entry dumpLogEntry
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, http.StatusBadRequest, entry.Status)
+	assert.Equal(t, `{"name":""}`, entry.RequestBody)
+	assert.JSONEq(t, `{"message":"name is required","code":400}`, entry.ResponseBody)
+	assert.False(t, entry.RequestBodyTruncated)
+	assert.False(t, entry.ResponseBodyTruncated)
+
+	// successful responses don't log bodies
+	logs.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"x"}`)))
+	assert.NotContains(t, logs.String(), "request_body")
+	assert.NotContains(t, logs.String(), "response_body")
+}
+
+func TestDumpBodiesOnErrorTruncates(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := LogMiddleware(lg, DumpBodiesOnError(8))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = io.Copy(w, strings.NewReader(strings.Repeat("b", 100)))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/upload", strings.NewReader(strings.Repeat("a", 100))))
+	assert.Equal(t, strings.Repeat("b", 100), rec.Body.String())
+
+	var entry dumpLogEntry
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "aaaaaaaa", entry.RequestBody)
+	assert.True(t, entry.RequestBodyTruncated)
+	assert.Equal(t, "bbbbbbbb", entry.ResponseBody)
+	assert.True(t, entry.ResponseBodyTruncated)
+}
+
+func TestContextLogger(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	var fromCtx *slog.Logger
+	h := RequestID()(ContextLogger(lg)(Recover(nil)(HandleErrors(nil, nil)(func(w http.ResponseWriter, r *http.Request) error {
+		fromCtx = slogx.FromContext(r.Context())
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/json":
+			JSONContext(r.Context(), w, map[string]any{"ch": make(chan int)})
+			return nil
+		default:
+			return errors.New("broken")
+		}
+	}))))
+
+	for path := range slices.Values([]string{"/error", "/panic", "/json"}) {
+		t.Run(path, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Request-Id", "req-1")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.NotNil(t, fromCtx)
+			var entry struct {
+				Method    string `json:"method"`
+				URI       string `json:"uri"`
+				Remote    string `json:"remote"`
+				RequestID string `json:"request_id"`
+			}
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry), logs.String())
+			assert.Equal(t, "GET", entry.Method)
+			assert.Equal(t, path, entry.URI)
+			assert.Equal(t, "192.0.2.1:1234", entry.Remote)
+			assert.Equal(t, "req-1", entry.RequestID)
+		})
+	}
+}
+
+func TestRecoverUsesLogger(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := Recover(lg)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, logs.String(), `"error":"boom"`)
+}
+
+func TestContextLoggerAttrsOnce(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := RequestID()(ContextLogger(lg)(LogMiddleware(nil)(RecoverErrors(nil, nil)(HandleErrors(nil, nil)(func(_ http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		return errors.New("broken")
+	})))))
+
+	for path := range slices.Values([]string{"/error", "/panic"}) {
+		t.Run(path, func(t *testing.T) {
+			logs.Reset()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			require.Len(t, lines, 2)
+			for line := range slices.Values(lines) {
+				for key := range slices.Values([]string{`"method":`, `"uri":`, `"remote":`, `"request_id":`}) {
+					assert.Equal(t, 1, strings.Count(line, key), "%s in %s", key, line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package middlewares
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const identity = "identity"
+
+// acceptSpec is a single element of a comma separated, q-value weighted header
+// like Accept or Accept-Encoding.
+type acceptSpec struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the values of a q-value weighted header as described in
+// RFC 9110 section 12.4.2. Values are lower cased, parameters other than the
+// weight are dropped and elements with a malformed weight are skipped.
+func parseAccept(values []string) []acceptSpec {
+	var specs []acceptSpec
+	for header := range slices.Values(values) {
+		for elem := range strings.SplitSeq(header, ",") {
+			value, params, _ := strings.Cut(elem, ";")
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value == "" {
+				continue
+			}
+
+			spec := acceptSpec{value: value, q: 1}
+			valid := true
+			for param := range strings.SplitSeq(params, ";") {
+				k, v, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(k), "q") {
+					continue
+				}
+				q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+					break
+				}
+				spec.q = q
+			}
+			if valid {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	return specs
+}
+
+// negotiateEncoding picks the content-coding for a response from the offers,
+// which are listed in the server's order of preference, based on the
+// Accept-Encoding header values of the request.
+//
+// The weights of the client take precedence, ties are broken by the order of
+// the offers. An offer is acceptable when the client lists it, or doesn't list
+// it but accepts "*", with a non-zero weight. The identity encoding is
+// acceptable unless it is refused explicitly, or through "*;q=0" without a more
+// specific entry for identity, and it only wins when the client prefers it.
+//
+// It returns "identity" when the response shouldn't be encoded and false when
+// no encoding, not even identity, is acceptable to the client.
+func negotiateEncoding(values []string, offers []string) (string, bool) {
+	specs := parseAccept(values)
+	if len(specs) == 0 {
+		return identity, true
+	}
+
+	weights := make(map[string]float64, len(specs))
+	for spec := range slices.Values(specs) {
+		if spec.value == "x-gzip" {
+			spec.value = gzipa
+		}
+		if q, ok := weights[spec.value]; !ok || spec.q > q {
+			weights[spec.value] = spec.q
+		}
+	}
+	weight := func(coding string) (float64, bool) {
+		if q, ok := weights[coding]; ok {
+			return q, true
+		}
+		q, ok := weights["*"]
+		return q, ok
+	}
+
+	var best string
+	var bestQ float64
+	for offer := range slices.Values(offers) {
+		if q, _ := weight(offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	identityQ, listed := weight(identity)
+	if !listed {
+		// identity is always acceptable when not mentioned, but it is the least
+		// preferred option.
+		identityQ = 0.001
+		if bestQ > 0 {
+			return best, true
+		}
+	}
+	if bestQ > 0 && bestQ >= identityQ {
+		return best, true
+	}
+	if identityQ > 0 {
+		return identity, true
+	}
+	return "", false
+}
+
+// negotiateContentType picks the media type of a response from the offers,
+// which are listed in the server's order of preference, based on the Accept
+// header values of the request.
+//
+// The weight of an offer is the weight of the most specific media range that
+// matches it, so "text/html" takes precedence over "text/*" and "*/*". The
+// weights of the client take precedence, ties are broken by the order of the
+// offers. Without an Accept header the first offer is picked. It returns false
+// when none of the offers is acceptable.
+func negotiateContentType(values []string, offers []string) (string, bool) {
+	specs := parseAccept(values)
+	if len(specs) == 0 {
+		if len(offers) == 0 {
+			return "", false
+		}
+		return offers[0], true
+	}
+
+	weight := func(offer string) float64 {
+		typ, _, _ := strings.Cut(offer, "/")
+		q, specificity := 0.0, -1
+		for spec := range slices.Values(specs) {
+			var s int
+			switch spec.value {
+			case offer:
+				s = 2
+			case typ + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity || (s == specificity && spec.q > q) {
+				q, specificity = spec.q, s
+			}
+		}
+		return q
+	}
+
+	var best string
+	var bestQ float64
+	for offer := range slices.Values(offers) {
+		if q := weight(offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
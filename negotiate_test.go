@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	specs := parseAccept([]string{"gzip;q=0.5, BR", "zstd;q=bogus, deflate ; q=0 ,, *;level=1;q=0.1"})
+	assert.Equal(t, []acceptSpec{
+		{value: "gzip", q: 0.5},
+		{value: "br", q: 1},
+		{value: "deflate", q: 0},
+		{value: "*", q: 0.1},
+	}, specs)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{zstda, br, gzipa, deflate}
+	tests := []struct {
+		name     string
+		header   []string
+		expected string
+		ok       bool
+	}{
+		{name: "no header", header: nil, expected: identity, ok: true},
+		{name: "empty header", header: []string{""}, expected: identity, ok: true},
+		{name: "single", header: []string{"gzip"}, expected: gzipa, ok: true},
+		{name: "server preference breaks ties", header: []string{"gzip, deflate, br"}, expected: br, ok: true},
+		{name: "client weights win", header: []string{"br;q=0.5, gzip;q=0.8"}, expected: gzipa, ok: true},
+		{name: "weighted match", header: []string{"gzip;q=0.5"}, expected: gzipa, ok: true},
+		{name: "refusal", header: []string{"gzip;q=0"}, expected: identity, ok: true},
+		{name: "x-gzip alias", header: []string{"x-gzip"}, expected: gzipa, ok: true},
+		{name: "wildcard", header: []string{"*"}, expected: zstda, ok: true},
+		{name: "wildcard with refusal", header: []string{"*, zstd;q=0"}, expected: br, ok: true},
+		{name: "identity preferred", header: []string{"identity;q=1, gzip;q=0.5"}, expected: identity, ok: true},
+		{name: "identity tie prefers coding", header: []string{"identity, gzip"}, expected: gzipa, ok: true},
+		{name: "unknown coding", header: []string{"compress"}, expected: identity, ok: true},
+		{name: "identity refused", header: []string{"identity;q=0"}, expected: "", ok: false},
+		{name: "wildcard refused", header: []string{"*;q=0"}, expected: "", ok: false},
+		{name: "wildcard refused with identity", header: []string{"*;q=0, identity"}, expected: identity, ok: true},
+		{name: "wildcard refused with coding", header: []string{"*;q=0, gzip"}, expected: gzipa, ok: true},
+		{name: "multiple headers", header: []string{"gzip;q=0.2", "deflate;q=0.4"}, expected: deflate, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, ok := negotiateEncoding(tt.header, offers)
+			assert.Equal(t, tt.expected, enc)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestCompressHandlerNotAcceptable(t *testing.T) {
+	called := false
+	h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		called = true
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{
+		Method: "GET",
+		Header: http.Header{"Accept-Encoding": []string{"identity;q=0, compress"}},
+	})
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestCompressHandlerEncodingsPreference(t *testing.T) {
+	h := CompressHandlerEncodings(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}), -1, "gzip", "br", "compress")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{
+		Method: "GET",
+		Header: http.Header{"Accept-Encoding": []string{"br, gzip, zstd"}},
+	})
+	assert.Equal(t, gzipa, w.Header().Get("Content-Encoding"))
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "application/problem+json", "text/plain", "text/html"}
+	tests := []struct {
+		name     string
+		header   []string
+		expected string
+		ok       bool
+	}{
+		{name: "no header", header: nil, expected: "application/json", ok: true},
+		{name: "exact", header: []string{"text/plain"}, expected: "text/plain", ok: true},
+		{name: "browser", header: []string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"}, expected: "text/html", ok: true},
+		{name: "wildcard", header: []string{"*/*"}, expected: "application/json", ok: true},
+		{name: "type wildcard", header: []string{"text/*"}, expected: "text/plain", ok: true},
+		{name: "specific refusal", header: []string{"text/*, text/plain;q=0"}, expected: "text/html", ok: true},
+		{name: "client weights win", header: []string{"application/json;q=0.5, application/problem+json"}, expected: "application/problem+json", ok: true},
+		{name: "parameters", header: []string{"text/plain; charset=utf-8"}, expected: "text/plain", ok: true},
+		{name: "not acceptable", header: []string{"image/png"}, expected: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := negotiateContentType(tt.header, offers)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/goccy/go-json"
+
+	"github.com/casualjim/middlewares/slogx"
+)
+
+const (
+	// ContentTypeProblemJSON is the media type of RFC 9457 problem details in JSON.
+	ContentTypeProblemJSON string = "application/problem+json"
+	// ContentTypeProblemXML is the media type of RFC 9457 problem details in XML.
+	ContentTypeProblemXML string = "application/problem+xml"
+
+	problemXMLNamespace = "urn:ietf:rfc:7807"
+)
+
+var _ error = (*ProblemDetails)(nil)
+
+// ProblemDetails is an RFC 9457 problem details object, describing an error in
+// an HTTP API response. It is an error, so ErrStatusCode, ErrBody and IsError
+// work with it.
+type ProblemDetails struct {
+	// Type is a URI reference that identifies the problem type, "about:blank"
+	// when empty.
+	Type string
+	// Title is a short, human-readable summary of the problem type.
+	Title string
+	// Status is the HTTP status code.
+	Status int
+	// Detail is a human-readable explanation of this occurrence of the problem.
+	Detail string
+	// Instance is a URI reference that identifies this occurrence of the problem.
+	Instance string
+	// Extensions holds additional members, they are serialized next to the
+	// standard members, which take precedence on conflicts.
+	Extensions map[string]any
+
+	header http.Header
+}
+
+// NewProblem creates problem details for the status code, with the status text
+// as title.
+func NewProblem(statusCode int, detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: detail,
+	}
+}
+
+func (p *ProblemDetails) Error() string {
+	if p.Detail != "" {
+		return fmt.Sprintf("[%d] %s", p.Status, p.Detail)
+	}
+	return fmt.Sprintf("[%d] %s", p.Status, p.Title)
+}
+
+func (p *ProblemDetails) httpStatus() int { return p.Status }
+
+func (p *ProblemDetails) httpHeader() http.Header { return p.header }
+
+func (p *ProblemDetails) httpBody() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// problemMembers holds the standard members of a problem details object.
+type problemMembers struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+var problemMemberNames = []string{"type", "title", "status", "detail", "instance"}
+
+// MarshalJSON serializes the standard members followed by the extension members.
+func (p *ProblemDetails) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(problemMembers{
+		Type:     p.Type,
+		Title:    p.Title,
+		Status:   p.Status,
+		Detail:   p.Detail,
+		Instance: p.Instance,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ext := make(map[string]any, len(p.Extensions))
+	for k, v := range p.Extensions {
+		if !slices.Contains(problemMemberNames, k) {
+			ext[k] = v
+		}
+	}
+	if len(ext) == 0 {
+		return b, nil
+	}
+
+	eb, err := json.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 2 {
+		return eb, nil
+	}
+	b = append(b[:len(b)-1], ',')
+	return append(b, eb[1:]...), nil
+}
+
+// UnmarshalJSON deserializes the standard members, the other members are
+// collected in Extensions.
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	var members problemMembers
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+
+	*p = ProblemDetails{
+		Type:     members.Type,
+		Title:    members.Title,
+		Status:   members.Status,
+		Detail:   members.Detail,
+		Instance: members.Instance,
+	}
+	for k, v := range all {
+		if slices.Contains(problemMemberNames, k) {
+			continue
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]any)
+		}
+		p.Extensions[k] = v
+	}
+	return nil
+}
+
+// MarshalXML serializes the problem details in the XML format of RFC 9457
+// appendix B. Arrays become a sequence of i elements and maps nested elements.
+func (p *ProblemDetails) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{Name: xml.Name{Space: problemXMLNamespace, Local: "problem"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	for member := range slices.Values([]struct {
+		name  string
+		value any
+	}{
+		{"type", p.Type},
+		{"title", p.Title},
+		{"status", p.Status},
+		{"detail", p.Detail},
+		{"instance", p.Instance},
+	}) {
+		if member.value == "" || member.value == 0 {
+			continue
+		}
+		if err := encodeProblemXML(e, member.name, member.value); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(p.Extensions))
+	for k := range p.Extensions {
+		if !slices.Contains(problemMemberNames, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for k := range slices.Values(keys) {
+		if err := encodeProblemXML(e, k, p.Extensions[k]); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+// encodeProblemXML writes value as an element with the given name. The value is
+// converted to its JSON data model first, so extension members look the same
+// in both formats.
+func encodeProblemXML(e *xml.Encoder, name string, value any) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	return encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: name}}, v)
+}
+
+func encodeXMLValue(e *xml.Encoder, start xml.StartElement, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return e.EncodeElement("", start)
+	case []any:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for item := range slices.Values(v) {
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: "i"}}, item); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case map[string]any:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for k := range slices.Values(keys) {
+			if err := encodeXMLValue(e, xml.StartElement{Name: xml.Name{Local: k}}, v[k]); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	default:
+		return e.EncodeElement(v, start)
+	}
+}
+
+// ProblemFromError returns the problem details in the chain of err. Other errors
+// are converted with the status code and public message HandleErrors uses, and
+// the field errors of a ValidationError as the errors extension member.
+func ProblemFromError(err error) *ProblemDetails {
+	var pd *ProblemDetails
+	if errors.As(err, &pd) && ErrStatusCode(err) == pd.Status {
+		return pd
+	}
+
+	code, msg := errorResponse(err)
+	p := NewProblem(code, msg)
+	if fields := ErrFieldErrors(err); len(fields) > 0 {
+		p.Extensions = map[string]any{"errors": fields}
+	}
+	return p
+}
+
+// WriteProblem writes the problem details as an application/problem+json
+// response, with the status of the problem or 500 when it has none.
+func WriteProblem(w http.ResponseWriter, p *ProblemDetails, headers ...http.Header) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("marshal problem details", slogx.Error(err))
+		JSONError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError, headers...)
+		return
+	}
+	writeProblem(w, ContentTypeProblemJSON, p.Status, b, headers)
+}
+
+// WriteProblemXML writes the problem details as an application/problem+xml
+// response, with the status of the problem or 500 when it has none.
+func WriteProblemXML(w http.ResponseWriter, p *ProblemDetails, headers ...http.Header) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(p); err != nil {
+		slog.Error("marshal problem details", slogx.Error(err))
+		JSONError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError, headers...)
+		return
+	}
+	writeProblem(w, ContentTypeProblemXML, p.Status, buf.Bytes(), headers)
+}
+
+func writeProblem(w http.ResponseWriter, contentType string, code int, body []byte, headers []http.Header) {
+	addHeaders(w, headers)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if code > 0 {
+		w.WriteHeader(code)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if _, err := w.Write(body); err != nil {
+		slog.Error("write problem details to response", slogx.Error(err))
+	}
+}
+
+// ProblemJSONError writes an application/problem+json response with the status
+// text of the code as title and error as detail. It has the same signature as
+// JSONError, so it can be used as the PanicRenderer of RecoverRendered.
+func ProblemJSONError(w http.ResponseWriter, error string, code int, headers ...http.Header) {
+	if code <= 0 {
+		code = http.StatusInternalServerError
+	}
+	WriteProblem(w, NewProblem(code, error), headers...)
+}
+
+// ProblemXMLError writes an application/problem+xml response with the status
+// text of the code as title and error as detail. It has the same signature as
+// JSONError, so it can be used as the PanicRenderer of RecoverRendered.
+func ProblemXMLError(w http.ResponseWriter, error string, code int, headers ...http.Header) {
+	if code <= 0 {
+		code = http.StatusInternalServerError
+	}
+	WriteProblemXML(w, NewProblem(code, error), headers...)
+}
@@ -0,0 +1,111 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestProblemDetailsError(t *testing.T) {
+	p := NewProblem(http.StatusNotFound, "no such widget")
+	var err error = p
+
+	assert.Equal(t, "[404] no such widget", err.Error())
+	assert.True(t, IsNotFound(err))
+	assert.False(t, IsServerError(err))
+	assert.Equal(t, http.StatusNotFound, ErrStatusCode(err))
+	assert.Equal(t, "no such widget", ErrBody(err))
+
+	p.Detail = ""
+	assert.Equal(t, "Not Found", ErrBody(err))
+	assert.True(t, IsServerError(NewProblem(http.StatusBadGateway, "")))
+}
+
+func TestProblemDetailsMarshalJSON(t *testing.T) {
+	p := &ProblemDetails{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   http.StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+		Extensions: map[string]any{
+			"balance":  30,
+			"accounts": []string{"/account/12345", "/account/67890"},
+			"status":   200,
+		},
+	}
+
+	b, err := p.MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "You do not have enough credit.",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30,
+		"accounts": ["/account/12345", "/account/67890"]
+	}`, string(b))
+
+	b, err = (&ProblemDetails{Extensions: map[string]any{"a": 1}}).MarshalJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(b))
+}
+
+func TestWriteProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteProblem(w, NewProblem(http.StatusConflict, "already exists"), http.Header{"X-Test": []string{"1"}})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ContentTypeProblemJSON, w.Header().Get("Content-Type"))
+	assert.Equal(t, "1", w.Header().Get("X-Test"))
+	assert.JSONEq(t, `{"title":"Conflict","status":409,"detail":"already exists"}`, w.Body.String())
+}
+
+func TestWriteProblemXML(t *testing.T) {
+	w := httptest.NewRecorder()
+	p := NewProblem(http.StatusForbidden, "Your current balance is 30, but that costs 50.")
+	p.Extensions = map[string]any{
+		"balance":  30,
+		"accounts": []string{"/account/12345", "/account/67890"},
+	}
+	WriteProblemXML(w, p)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ContentTypeProblemXML, w.Header().Get("Content-Type"))
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<problem xmlns="urn:ietf:rfc:7807">` +
+		`<title>Forbidden</title>` +
+		`<status>403</status>` +
+		`<detail>Your current balance is 30, but that costs 50.</detail>` +
+		`<accounts><i>/account/12345</i><i>/account/67890</i></accounts>` +
+		`<balance>30</balance>` +
+		`</problem>`
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestProblemErrorRenderers(t *testing.T) {
+	w := httptest.NewRecorder()
+	ProblemJSONError(w, "boom", 0)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"title":"Internal Server Error","status":500,"detail":"boom"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ProblemXMLError(w, "boom", http.StatusBadRequest)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, strings.HasSuffix(w.Body.String(), `<problem xmlns="urn:ietf:rfc:7807"><title>Bad Request</title><status>400</status><detail>boom</detail></problem>`))
+
+	// usable as panic renderer
+	w = httptest.NewRecorder()
+	RecoverRendered(nil, ProblemJSONError)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ContentTypeProblemJSON, w.Header().Get("Content-Type"))
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"runtime"
@@ -12,14 +13,57 @@ import (
 
 type PanicRenderer func(http.ResponseWriter, string, int, ...http.Header)
 
+// Recover catches panics in HTTP handlers and responds with a JSONError. The
+// panic is logged with lg, or the context logger when lg is nil.
 func Recover(lg *slog.Logger) func(http.Handler) http.Handler {
 	return RecoverRendered(lg, nil)
 }
 
+// RecoverRendered catches panics like Recover, but renders the response with
+// renderPanic.
 func RecoverRendered(lg *slog.Logger, renderPanic PanicRenderer) func(http.Handler) http.Handler {
 	if renderPanic == nil {
 		renderPanic = JSONError
 	}
+	return recoverWith(func(w http.ResponseWriter, r *http.Request, rvr any, stack []byte) {
+		if err, ok := rvr.(error); ok {
+			requestLogger(r.Context(), lg).Warn("", slogx.Error(err), slogx.ByteString("stack", stack))
+			renderPanic(w, string(stack), http.StatusInternalServerError)
+		} else {
+			requestLogger(r.Context(), lg).Info("", slog.Any("error", rvr), slogx.ByteString("stack", stack))
+			renderPanic(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RecoverErrors catches panics in HTTP handlers like Recover, but renders them
+// with an ErrorRenderer, like the one created by NegotiateErrors. The panic
+// value is rendered as an error with status 500, unless it is an error that
+// carries another status. The stack is logged, not rendered. A panic with
+// http.ErrAbortHandler is passed on, so the response is aborted.
+func RecoverErrors(lg *slog.Logger, render ErrorRenderer) func(http.Handler) http.Handler {
+	if render == nil {
+		render = RenderJSONError
+	}
+	return recoverWith(func(w http.ResponseWriter, r *http.Request, rvr any, stack []byte) {
+		if rvr == http.ErrAbortHandler {
+			panic(rvr)
+		}
+
+		var err error
+		if rerr, ok := rvr.(error); ok {
+			err = fmt.Errorf("panic: %w", rerr)
+		} else {
+			err = fmt.Errorf("panic: %v", rvr)
+		}
+
+		attrs := append([]slog.Attr{slogx.Error(err)}, requestAttrs(r, lg)...)
+		requestLogger(r.Context(), lg).LogAttrs(r.Context(), slog.LevelError, "recovered from panic", append(attrs, slogx.ByteString("stack", stack))...)
+		render(w, r, err)
+	})
+}
+
+func recoverWith(handlePanic func(http.ResponseWriter, *http.Request, any, []byte)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			statusWritten := int32(-1)
@@ -37,14 +81,7 @@ func RecoverRendered(lg *slog.Logger, renderPanic PanicRenderer) func(http.Handl
 				if rvr := recover(); rvr != nil {
 					stack := make([]byte, 8*1024)
 					stack = stack[:runtime.Stack(stack, false)]
-
-					if err, ok := rvr.(error); ok {
-						slog.Warn("", slogx.Error(err), slogx.ByteString("stack", stack))
-						renderPanic(w, string(stack), http.StatusInternalServerError)
-					} else {
-						slog.Info("", slog.Any("error", rvr), slogx.ByteString("stack", stack))
-						renderPanic(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-					}
+					handlePanic(w, r, rvr, stack)
 				}
 			}()
 
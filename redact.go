@@ -0,0 +1,268 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// redacted replaces the values of secrets in logs.
+const redacted = "[REDACTED]"
+
+var (
+	defaultRedactHeaders = []string{
+		"Authorization",
+		"Proxy-Authorization",
+		"Cookie",
+		"Set-Cookie",
+		"X-Api-Key",
+		"X-Auth-Token",
+	}
+	defaultRedactHeaderPattern = regexp.MustCompile(`(?i)(token|secret|api-?key|password)`)
+	defaultRedactFields        = []string{
+		"password",
+		"passwd",
+		"secret",
+		"token",
+		"access_token",
+		"refresh_token",
+		"id_token",
+		"client_secret",
+		"api_key",
+		"apikey",
+	}
+)
+
+// DefaultRedactor redacts the Authorization, Cookie, Set-Cookie and API key
+// headers, headers with token, secret or password in their name, and JSON
+// fields like password and token. It's used by DebugDumpMiddleware,
+// LoggingTransport and LogDumpErrorsMiddleware unless configured otherwise.
+var DefaultRedactor = NewRedactor()
+
+// RedactOption configures a Redactor.
+type RedactOption func(*Redactor)
+
+// RedactHeaders adds header names to redact.
+func RedactHeaders(names ...string) RedactOption {
+	return func(r *Redactor) {
+		for name := range slices.Values(names) {
+			r.headers[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+		}
+	}
+}
+
+// RedactHeaderPattern adds a pattern for header names to redact.
+func RedactHeaderPattern(pattern *regexp.Regexp) RedactOption {
+	return func(r *Redactor) {
+		r.headerPatterns = append(r.headerPatterns, pattern)
+	}
+}
+
+// RedactFields adds JSON body fields to redact. A name like "password" redacts
+// the field with that name in every object of the body, a dotted path like
+// "user.credentials.pin" only the field at that path from the root object.
+// Arrays are transparent in paths, and names are matched case-insensitively.
+func RedactFields(paths ...string) RedactOption {
+	return func(r *Redactor) {
+		for p := range slices.Values(paths) {
+			p = strings.ToLower(p)
+			if strings.Contains(p, ".") {
+				r.paths = append(r.paths, strings.Split(p, "."))
+			} else {
+				r.fields[p] = struct{}{}
+			}
+		}
+	}
+}
+
+// WithoutDefaultRedactions leaves out the well-known secret headers and fields,
+// so only the ones from the other options are redacted, in whatever order the
+// options are given.
+func WithoutDefaultRedactions() RedactOption {
+	return func(r *Redactor) {
+		r.noDefaults = true
+	}
+}
+
+// Redactor masks secrets in headers and JSON bodies before they are logged.
+// A nil Redactor doesn't redact anything.
+type Redactor struct {
+	headers        map[string]struct{}
+	headerPatterns []*regexp.Regexp
+	fields         map[string]struct{}
+	paths          [][]string
+	noDefaults     bool
+}
+
+// NewRedactor creates a Redactor for the well-known secret headers and fields,
+// extended or replaced by the options.
+func NewRedactor(opts ...RedactOption) *Redactor {
+	r := &Redactor{
+		headers: make(map[string]struct{}),
+		fields:  make(map[string]struct{}),
+	}
+	for opt := range slices.Values(opts) {
+		opt(r)
+	}
+	if !r.noDefaults {
+		r.headerPatterns = append(r.headerPatterns, defaultRedactHeaderPattern)
+		RedactHeaders(defaultRedactHeaders...)(r)
+		RedactFields(defaultRedactFields...)(r)
+	}
+	return r
+}
+
+func (r *Redactor) redactsHeader(name string) bool {
+	if _, ok := r.headers[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		return true
+	}
+	return slices.ContainsFunc(r.headerPatterns, func(p *regexp.Regexp) bool {
+		return p.MatchString(name)
+	})
+}
+
+// Header returns a copy of h with the values of secret headers redacted.
+func (r *Redactor) Header(h http.Header) http.Header {
+	if r == nil || h == nil {
+		return h
+	}
+	out := h.Clone()
+	for k, v := range out {
+		if r.redactsHeader(k) {
+			out[k] = slices.Repeat([]string{redacted}, len(v))
+		}
+	}
+	return out
+}
+
+// Body returns body with the values of secret fields redacted when it is a JSON
+// document, other bodies are returned as is.
+func (r *Redactor) Body(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if r == nil || len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return body
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		// truncated or otherwise malformed, redact what looks like a string field
+		return r.redactJSONText(body)
+	}
+	if !r.redactValue(doc, nil) {
+		return body
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// redactValue redacts the secret fields in v, which is at path, and reports
+// whether anything was redacted.
+func (r *Redactor) redactValue(v any, path []string) bool {
+	var changed bool
+	switch x := v.(type) {
+	case map[string]any:
+		for k, fv := range x {
+			key := strings.ToLower(k)
+			fieldPath := append(slices.Clip(path), key)
+			if r.redactsField(key, fieldPath) {
+				x[k] = redacted
+				changed = true
+				continue
+			}
+			if r.redactValue(fv, fieldPath) {
+				changed = true
+			}
+		}
+	case []any:
+		for item := range slices.Values(x) {
+			if r.redactValue(item, path) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// jsonStringField matches a string member of a JSON object, the closing quote
+// is optional so values cut off by truncation match too.
+var jsonStringField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// redactJSONText redacts the string values of the secret fields in text that
+// can't be parsed as JSON. Only fields matched by name are redacted.
+func (r *Redactor) redactJSONText(body []byte) []byte {
+	return jsonStringField.ReplaceAllFunc(body, func(m []byte) []byte {
+		sub := jsonStringField.FindSubmatch(m)
+		if _, ok := r.fields[strings.ToLower(string(sub[1]))]; !ok {
+			return m
+		}
+		return []byte(`"` + string(sub[1]) + `"` + string(sub[2]) + `"` + redacted + `"`)
+	})
+}
+
+func (r *Redactor) redactsField(key string, path []string) bool {
+	if _, ok := r.fields[key]; ok {
+		return true
+	}
+	return slices.ContainsFunc(r.paths, func(p []string) bool {
+		return slices.Equal(p, path)
+	})
+}
+
+// Dump redacts a request or response dump made with httputil: the values of
+// secret header lines and secret fields of a JSON body. A body with chunked
+// transfer coding is decoded, so the redacted dump has the body without the
+// chunk framing.
+func (r *Redactor) Dump(dump []byte) []byte {
+	if r == nil {
+		return dump
+	}
+	head, body, found := bytes.Cut(dump, []byte("\r\n\r\n"))
+
+	var chunked bool
+	var out bytes.Buffer
+	out.Grow(len(dump))
+	for i, line := range bytes.Split(head, []byte("\r\n")) {
+		if i > 0 {
+			out.WriteString("\r\n")
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if ok && i > 0 && r.redactsHeader(string(bytes.TrimSpace(name))) {
+			out.Write(name)
+			out.WriteString(": " + redacted)
+			continue
+		}
+		if ok && i > 0 && strings.EqualFold(string(bytes.TrimSpace(name)), "Transfer-Encoding") {
+			chunked = bytes.Contains(bytes.ToLower(value), []byte("chunked"))
+		}
+		out.Write(line)
+	}
+	if !found {
+		return out.Bytes()
+	}
+
+	out.WriteString("\r\n\r\n")
+	if !chunked {
+		out.Write(r.Body(body))
+		return out.Bytes()
+	}
+	decoded, err := io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(body)))
+	if err != nil {
+		// cut off or otherwise malformed, redact what looks like a string field
+		out.Write(r.redactJSONText(body))
+		return out.Bytes()
+	}
+	out.Write(r.Body(decoded))
+	return out.Bytes()
+}
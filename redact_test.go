@@ -0,0 +1,207 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestRedactorHeader(t *testing.T) {
+	h := http.Header{
+		"Authorization":  {"Bearer abc"},
+		"Cookie":         {"session=1", "theme=dark"},
+		"X-Csrf-Token":   {"t0k3n"},
+		"X-Tenant":       {"acme"},
+		"Content-Type":   {"application/json"},
+		"X-Internal-Pin": {"1234"},
+	}
+
+	out := DefaultRedactor.Header(h)
+	assert.Equal(t, []string{"[REDACTED]"}, out["Authorization"])
+	assert.Equal(t, []string{"[REDACTED]", "[REDACTED]"}, out["Cookie"])
+	assert.Equal(t, []string{"[REDACTED]"}, out["X-Csrf-Token"])
+	assert.Equal(t, []string{"acme"}, out["X-Tenant"])
+	assert.Equal(t, []string{"1234"}, out["X-Internal-Pin"])
+	assert.Equal(t, "Bearer abc", h.Get("Authorization"), "the original is left alone")
+
+	custom := NewRedactor(RedactHeaders("x-tenant"), RedactHeaderPattern(regexp.MustCompile(`(?i)pin$`)))
+	out = custom.Header(h)
+	assert.Equal(t, []string{"[REDACTED]"}, out["X-Tenant"])
+	assert.Equal(t, []string{"[REDACTED]"}, out["X-Internal-Pin"])
+	assert.Equal(t, []string{"[REDACTED]"}, out["Authorization"])
+
+	only := NewRedactor(WithoutDefaultRedactions(), RedactHeaders("X-Tenant"))
+	out = only.Header(h)
+	assert.Equal(t, []string{"Bearer abc"}, out["Authorization"])
+	assert.Equal(t, []string{"[REDACTED]"}, out["X-Tenant"])
+
+	only = NewRedactor(RedactHeaders("X-Tenant"), WithoutDefaultRedactions())
+	out = only.Header(h)
+	assert.Equal(t, []string{"Bearer abc"}, out["Authorization"])
+	assert.Equal(t, []string{"[REDACTED]"}, out["X-Tenant"])
+
+	var none *Redactor
+	assert.Equal(t, h, none.Header(h))
+}
+
+func TestRedactorBody(t *testing.T) {
+	r := NewRedactor(RedactFields("user.pin"))
+
+	body := []byte(`{"user":{"name":"jane","Password":"hunter2","pin":"1234"},"items":[{"token":"abc","qty":10000000000000000001}],"pin":"5678"}`)
+	assert.JSONEq(t,
+		`{"user":{"name":"jane","Password":"[REDACTED]","pin":"[REDACTED]"},"items":[{"token":"[REDACTED]","qty":10000000000000000001}],"pin":"5678"}`,
+		string(r.Body(body)))
+
+	// nothing to redact leaves the body untouched
+	plain := []byte(`{"b": 1, "a": 2}`)
+	assert.Equal(t, plain, r.Body(plain))
+	assert.Equal(t, []byte("password=hunter2"), r.Body([]byte("password=hunter2")))
+
+	// truncated bodies are redacted on a best effort basis
+	truncated := []byte(`{"name":"jane","password":"hunt`)
+	assert.Equal(t, `{"name":"jane","password":"[REDACTED]"`, string(r.Body(truncated)))
+}
+
+func TestRedactorDump(t *testing.T) {
+	dump := "POST /login HTTP/1.1\r\nHost: example.com\r\nAuthorization: Basic Zm9vOmJhcg==\r\nContent-Type: application/json\r\n\r\n{\"user\":\"jane\",\"password\":\"hunter2\"}"
+	out := string(DefaultRedactor.Dump([]byte(dump)))
+	assert.Equal(t, "POST /login HTTP/1.1\r\nHost: example.com\r\nAuthorization: [REDACTED]\r\nContent-Type: application/json\r\n\r\n{\"password\":\"[REDACTED]\",\"user\":\"jane\"}", out)
+
+	headOnly := "HTTP/1.1 200 OK\r\nSet-Cookie: session=abc\r\n\r\n"
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nSet-Cookie: [REDACTED]\r\n\r\n", string(DefaultRedactor.Dump([]byte(headOnly))))
+
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Type: application/json\r\n\r\n" +
+		"11\r\n{\"access_token\":\"\r\n" + "b\r\nSECRET123\"}\r\n" + "0\r\n\r\n"
+	out = string(DefaultRedactor.Dump([]byte(chunked)))
+	assert.NotContains(t, out, "SECRET123")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n{\"access_token\":\"[REDACTED]\"}"), out)
+
+	cutOff := "POST /login HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n16\r\n{\"password\":\"hunter2\"}"
+	assert.NotContains(t, string(DefaultRedactor.Dump([]byte(cutOff))), "hunter2")
+}
+
+func captureDefaultLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: level})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &logs
+}
+
+func TestDebugDumpMiddlewareRedacts(t *testing.T) {
+	logs := captureDefaultLogs(t, slog.LevelInfo)
+
+	h := DebugDumpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(b), "hunter2", "the handler sees the original body")
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		JSON(w, map[string]string{"access_token": "xyz"})
+	}))
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("Authorization", "Bearer abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	for secret := range strings.SplitSeq("hunter2 Bearer abc s3cr3t xyz", " ") {
+		assert.NotContains(t, out, secret)
+	}
+	assert.Contains(t, out, "[REDACTED]")
+}
+
+func TestDebugDumpMiddlewareRedactsChunked(t *testing.T) {
+	logs := captureDefaultLogs(t, slog.LevelInfo)
+
+	h := DebugDumpMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(b), "hunter2", "the handler sees the original body")
+	}))
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"password":"hunter2"}`))
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NotContains(t, logs.String(), "hunter2")
+	assert.Contains(t, logs.String(), "[REDACTED]")
+}
+
+func TestLoggingTransportRedactsChunked(t *testing.T) {
+	logs := captureDefaultLogs(t, slog.LevelDebug)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":`)
+		w.(http.Flusher).Flush()
+		_, _ = io.WriteString(w, `"SECRET123"}`)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: LoggingTransportDebug(http.DefaultTransport, AlwaysDumpResponseBody())}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, `{"access_token":"SECRET123"}`, string(b), "the caller sees the original body")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	assert.NotContains(t, logs.String(), "SECRET123")
+	assert.Contains(t, logs.String(), "[REDACTED]")
+}
+
+func TestLoggingTransportRedacts(t *testing.T) {
+	logs := captureDefaultLogs(t, slog.LevelDebug)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Set-Cookie", "session=s3cr3t")
+		JSON(w, map[string]string{"refresh_token": "xyz"})
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: LoggingTransportDebug(http.DefaultTransport)}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader(`{"client_secret":"hunter2"}`))
+	require.NoError(t, err)
+	req.Header.Set("X-Api-Key", "k3y")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(b), "xyz", "the caller sees the original body")
+
+	out := logs.String()
+	for secret := range strings.SplitSeq("hunter2 k3y s3cr3t xyz", " ") {
+		assert.NotContains(t, out, secret)
+	}
+
+	logs.Reset()
+	client.Transport = LoggingTransportDebug(http.DefaultTransport, RedactDumps(nil))
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Contains(t, logs.String(), "s3cr3t")
+}
+
+func TestLogDumpErrorsMiddlewareRedacts(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := LogDumpErrorsMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		JSONError(w, "invalid credentials", http.StatusUnauthorized)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"user":"jane","password":"hunter2"}`)))
+	assert.NotContains(t, logs.String(), "hunter2")
+	assert.Contains(t, logs.String(), "jane")
+}
@@ -0,0 +1,144 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"time"
+
+	"github.com/casualjim/middlewares/slogx"
+)
+
+// HeaderRequestID is the default header that carries the request id.
+const HeaderRequestID = "X-Request-Id"
+
+// maxRequestIDLength limits the length of request ids accepted from clients.
+const maxRequestIDLength = 128
+
+type contextRequestIDT struct{}
+
+var contextRequestID contextRequestIDT
+
+// requestID is the id of a request and the header it travels in.
+type requestID struct {
+	id     string
+	header string
+}
+
+// RequestIDOption configures the middleware created by RequestID.
+type RequestIDOption func(*requestIDOptions)
+
+type requestIDOptions struct {
+	header   string
+	generate func() string
+	trust    bool
+}
+
+// RequestIDHeader sets the header that carries the request id, X-Request-Id by
+// default.
+func RequestIDHeader(name string) RequestIDOption {
+	return func(o *requestIDOptions) {
+		o.header = textproto.CanonicalMIMEHeaderKey(name)
+	}
+}
+
+// RequestIDGenerator sets the function that generates request ids, which
+// generates UUIDv7 ids by default.
+func RequestIDGenerator(generate func() string) RequestIDOption {
+	return func(o *requestIDOptions) {
+		o.generate = generate
+	}
+}
+
+// IgnoreIncomingRequestID makes RequestID generate an id for every request,
+// instead of using the id in the request header.
+func IgnoreIncomingRequestID() RequestIDOption {
+	return func(o *requestIDOptions) {
+		o.trust = false
+	}
+}
+
+// RequestID assigns every request an id, so it can be correlated across logs
+// and services. The id is read from the X-Request-Id header of the request, or
+// generated when it is missing or invalid. It is stored in the request context,
+// available through RequestIDFromContext, and set on the response header.
+//
+// The id is added as request_id to the context logger, see slogx.FromContext, so
+// the log lines of the middlewares in this package include it. LoggingTransport
+// forwards it on outbound requests made with the request context.
+func RequestID(opts ...RequestIDOption) func(http.Handler) http.Handler {
+	o := &requestIDOptions{
+		header:   HeaderRequestID,
+		generate: newUUIDv7,
+		trust:    true,
+	}
+	for opt := range slices.Values(opts) {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id string
+			if o.trust {
+				id = r.Header.Get(o.header)
+			}
+			if !validRequestID(id) {
+				id = o.generate()
+			}
+
+			w.Header().Set(o.header, id)
+			ctx := context.WithValue(r.Context(), contextRequestID, requestID{id: id, header: o.header})
+			ctx = slogx.WithContext(ctx, slogx.FromContext(ctx).With(slog.String("request_id", id)))
+			r2 := r.WithContext(ctx)
+			next.ServeHTTP(w, r2)
+			reportRoute(r2)
+		})
+	}
+}
+
+// RequestIDFromContext returns the id the RequestID middleware assigned to the
+// request, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	rid, _ := ctx.Value(contextRequestID).(requestID)
+	return rid.id
+}
+
+// validRequestID reports whether id is a non-empty, reasonably sized string of
+// visible ASCII characters, so it's safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newUUIDv7 generates a time ordered UUID as described in RFC 9562.
+func newUUIDv7() string {
+	var u [16]byte
+	_, _ = rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	u[0], u[1], u[2], u[3], u[4], u[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	u[6] = 0x70 | u[6]&0x0f
+	u[8] = 0x80 | u[8]&0x3f
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
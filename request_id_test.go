@@ -0,0 +1,116 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+)
+
+var uuidv7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID()(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Regexp(t, uuidv7, seen)
+	assert.Equal(t, seen, w.Header().Get("X-Request-Id"))
+
+	first := seen
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.NotEqual(t, first, seen)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-Id"))
+
+	for invalid := range strings.SplitSeq("has space|"+strings.Repeat("x", 129)+"|new\nline", "|") {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header["X-Request-Id"] = []string{invalid}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Regexp(t, uuidv7, seen)
+	}
+
+	assert.Empty(t, RequestIDFromContext(req.Context()))
+}
+
+func TestRequestIDOptions(t *testing.T) {
+	var seen string
+	h := RequestID(
+		RequestIDHeader("x-correlation-id"),
+		RequestIDGenerator(func() string { return "generated" }),
+		IgnoreIncomingRequestID(),
+	)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-Id", "from-client")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "generated", seen)
+	assert.Equal(t, "generated", w.Header().Get("X-Correlation-Id"))
+	assert.Empty(t, w.Header().Get("X-Request-Id"))
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	h := RequestID()(LogMiddleware(lg)(HandleErrors(lg, nil)(func(http.ResponseWriter, *http.Request) error {
+		return Error(http.StatusInternalServerError, "boom")
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	for line := range bytes.Lines(logs.Bytes()) {
+		var entry struct {
+			RequestID string `json:"request_id"`
+		}
+		require.NoError(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "req-1", entry.RequestID)
+	}
+}
+
+func TestLoggingTransportForwardsRequestID(t *testing.T) {
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Correlation-Id")
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: LoggingTransport(http.DefaultTransport)}
+	h := RequestID(RequestIDHeader("X-Correlation-Id"))(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", upstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Empty(t, req.Header.Get("X-Correlation-Id"), "the caller's request is left alone")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-Id", "corr-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "corr-1", forwarded)
+}
@@ -0,0 +1,209 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/goccy/go-json"
+
+	"github.com/casualjim/middlewares/slogx"
+)
+
+// Code is a canonical RPC status code, as used by gRPC and Connect.
+type Code uint32
+
+const (
+	CodeOK Code = iota
+	CodeCanceled
+	CodeUnknown
+	CodeInvalidArgument
+	CodeDeadlineExceeded
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeResourceExhausted
+	CodeFailedPrecondition
+	CodeAborted
+	CodeOutOfRange
+	CodeUnimplemented
+	CodeInternal
+	CodeUnavailable
+	CodeDataLoss
+	CodeUnauthenticated
+)
+
+var codeNames = [...]string{
+	CodeOK:                 "ok",
+	CodeCanceled:           "canceled",
+	CodeUnknown:            "unknown",
+	CodeInvalidArgument:    "invalid_argument",
+	CodeDeadlineExceeded:   "deadline_exceeded",
+	CodeNotFound:           "not_found",
+	CodeAlreadyExists:      "already_exists",
+	CodePermissionDenied:   "permission_denied",
+	CodeResourceExhausted:  "resource_exhausted",
+	CodeFailedPrecondition: "failed_precondition",
+	CodeAborted:            "aborted",
+	CodeOutOfRange:         "out_of_range",
+	CodeUnimplemented:      "unimplemented",
+	CodeInternal:           "internal",
+	CodeUnavailable:        "unavailable",
+	CodeDataLoss:           "data_loss",
+	CodeUnauthenticated:    "unauthenticated",
+}
+
+// codeStatuses maps the codes to HTTP statuses like the Connect protocol does.
+var codeStatuses = [...]int{
+	CodeOK:                 http.StatusOK,
+	CodeCanceled:           499,
+	CodeUnknown:            http.StatusInternalServerError,
+	CodeInvalidArgument:    http.StatusBadRequest,
+	CodeDeadlineExceeded:   http.StatusGatewayTimeout,
+	CodeNotFound:           http.StatusNotFound,
+	CodeAlreadyExists:      http.StatusConflict,
+	CodePermissionDenied:   http.StatusForbidden,
+	CodeResourceExhausted:  http.StatusTooManyRequests,
+	CodeFailedPrecondition: http.StatusBadRequest,
+	CodeAborted:            http.StatusConflict,
+	CodeOutOfRange:         http.StatusBadRequest,
+	CodeUnimplemented:      http.StatusNotImplemented,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeDataLoss:           http.StatusInternalServerError,
+	CodeUnauthenticated:    http.StatusUnauthorized,
+}
+
+// String returns the name of the code as used by the Connect protocol, like
+// "not_found".
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return codeNames[CodeUnknown]
+}
+
+// HTTPStatus returns the HTTP status for the code, as mapped by the Connect
+// protocol.
+func (c Code) HTTPStatus() int {
+	if int(c) < len(codeStatuses) {
+		return codeStatuses[c]
+	}
+	return http.StatusInternalServerError
+}
+
+// ParseCode returns the code with the Connect name, like "not_found".
+func ParseCode(name string) (Code, bool) {
+	for i, n := range codeNames {
+		if n == name {
+			return Code(i), true
+		}
+	}
+	return CodeUnknown, false
+}
+
+// CodeFromStatus returns the code that best describes an HTTP status, the
+// inverse of HTTPStatus for the statuses it returns.
+func CodeFromStatus(statusCode int) Code {
+	switch statusCode {
+	case http.StatusOK:
+		return CodeOK
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return CodeUnimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeDeadlineExceeded
+	case http.StatusConflict:
+		return CodeAlreadyExists
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return CodeFailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return CodeOutOfRange
+	case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+		return CodeResourceExhausted
+	case 499:
+		return CodeCanceled
+	case http.StatusInternalServerError:
+		return CodeInternal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeUnknown
+	}
+}
+
+// CodeError creates an HTTP error with a canonical code, its status is the HTTP
+// status of the code.
+func CodeError(code Code, msg string) error {
+	return withStack(&httpError{statusCode: code.HTTPStatus(), body: msg, code: code}, 1)
+}
+
+// WrapCode annotates err with a canonical code, like Wrap does with a status
+// code. WrapCode returns nil when err is nil.
+func WrapCode(code Code, err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if msg == "" {
+		msg = http.StatusText(code.HTTPStatus())
+	}
+	return withStack(&httpError{statusCode: code.HTTPStatus(), body: msg, cause: err, code: code}, 1)
+}
+
+// ErrCode returns the canonical code of e: the code of the outermost HTTP error
+// in its chain when it was created with one, and otherwise the code for its
+// status code. Canceled and expired contexts map to CodeCanceled and
+// CodeDeadlineExceeded, nil to CodeOK and other errors to CodeUnknown.
+func ErrCode(e error) Code {
+	if e == nil {
+		return CodeOK
+	}
+	var se statusError
+	if errors.As(e, &se) {
+		if he, ok := se.(*httpError); ok && he.code != CodeOK {
+			return he.code
+		}
+		return CodeFromStatus(se.httpStatus())
+	}
+	if code := ErrStatusCode(e); code != 0 {
+		return CodeFromStatus(code)
+	}
+	switch {
+	case errors.Is(e, context.Canceled):
+		return CodeCanceled
+	case errors.Is(e, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	default:
+		return CodeUnknown
+	}
+}
+
+// RenderConnectError renders the error in the JSON format of the Connect
+// protocol, {"code":"not_found","message":"..."}, with the HTTP status of its
+// code.
+func RenderConnectError(w http.ResponseWriter, r *http.Request, err error) {
+	code := ErrCode(err)
+	if code == CodeOK {
+		code = CodeUnknown
+	}
+	addErrHeader(w, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	status := code.HTTPStatus()
+	w.WriteHeader(status)
+
+	msg := publicErrBody(err, status)
+	if err := json.NewEncoder(w).Encode(struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	}{code.String(), msg}); err != nil {
+		requestLogger(r.Context(), nil).Error("write connect error to response", slogx.Error(err))
+	}
+}
@@ -0,0 +1,88 @@
+package middlewares_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/casualjim/middlewares"
+)
+
+func TestCodeMapping(t *testing.T) {
+	for code := CodeCanceled; code <= CodeUnauthenticated; code++ {
+		parsed, ok := ParseCode(code.String())
+		assert.True(t, ok, code.String())
+		assert.Equal(t, code, parsed)
+	}
+
+	// every status a code maps to maps back to a code with that status
+	for code := CodeCanceled; code <= CodeUnauthenticated; code++ {
+		status := code.HTTPStatus()
+		assert.Equal(t, status, CodeFromStatus(status).HTTPStatus(), code.String())
+	}
+
+	assert.Equal(t, "unknown", Code(100).String())
+	assert.Equal(t, http.StatusInternalServerError, Code(100).HTTPStatus())
+	_, ok := ParseCode("bogus")
+	assert.False(t, ok)
+
+	assert.Equal(t, CodeNotFound, CodeFromStatus(http.StatusNotFound))
+	assert.Equal(t, CodePermissionDenied, CodeFromStatus(http.StatusForbidden))
+	assert.Equal(t, CodeUnavailable, CodeFromStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, CodeUnknown, CodeFromStatus(http.StatusTeapot))
+}
+
+func TestErrCode(t *testing.T) {
+	err := CodeError(CodeFailedPrecondition, "bucket is not empty")
+	assert.Equal(t, CodeFailedPrecondition, ErrCode(fmt.Errorf("delete: %w", err)))
+	assert.True(t, IsBadRequest(err))
+	assert.Equal(t, "bucket is not empty", ErrBody(err))
+
+	cause := errors.New("connection refused")
+	wrapped := WrapCode(CodeUnavailable, cause, "")
+	assert.ErrorIs(t, wrapped, cause)
+	assert.Equal(t, http.StatusServiceUnavailable, ErrStatusCode(wrapped))
+	assert.Equal(t, "Service Unavailable", ErrBody(wrapped))
+	assert.NoError(t, WrapCode(CodeInternal, nil, ""))
+
+	assert.Equal(t, CodeNotFound, ErrCode(Error(http.StatusNotFound, "missing")))
+	assert.Equal(t, CodeInvalidArgument, ErrCode(NewProblem(http.StatusUnprocessableEntity, "")))
+	assert.Equal(t, CodeCanceled, ErrCode(fmt.Errorf("query: %w", context.Canceled)))
+	assert.Equal(t, CodeDeadlineExceeded, ErrCode(context.DeadlineExceeded))
+	assert.Equal(t, CodeUnknown, ErrCode(cause))
+	assert.Equal(t, CodeOK, ErrCode(nil))
+}
+
+func TestRenderConnectError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{CodeError(CodeNotFound, "no such user"), http.StatusNotFound, `{"code":"not_found","message":"no such user"}`},
+		{CodeError(CodeAborted, "retry the transaction"), http.StatusConflict, `{"code":"aborted","message":"retry the transaction"}`},
+		{Error(http.StatusUnprocessableEntity, "bad name"), http.StatusBadRequest, `{"code":"invalid_argument","message":"bad name"}`},
+		{WrapCode(CodeInternal, errors.New("secret"), ""), http.StatusInternalServerError, `{"code":"internal","message":"Internal Server Error"}`},
+		{errors.New("secret"), http.StatusInternalServerError, `{"code":"unknown","message":"Internal Server Error"}`},
+	}
+
+	for tt := range slices.Values(tests) {
+		w := httptest.NewRecorder()
+		RenderConnectError(w, httptest.NewRequest("POST", "/", nil), tt.err)
+		assert.Equal(t, tt.status, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, tt.body, w.Body.String())
+
+		// round trip
+		decoded := ErrorFromResponse(w.Result())
+		require.Error(t, decoded)
+		assert.Equal(t, ErrCode(tt.err), ErrCode(decoded))
+	}
+}
@@ -1,13 +1,17 @@
 package slogx
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
 )
 
 // Error returns a slog.Attr representing the provided error.
-// The attribute key is "error" and the value is the error's message.
+// The attribute key is "error" and the value is the error's message. When an
+// error in the chain of err implements slog.LogValuer and logs as a group, like
+// errors with a captured stack do, the value is that group with the message of
+// err as "msg".
 //
 // Parameters:
 //   - err: The error to be converted into a slog.Attr.
@@ -15,6 +19,18 @@ import (
 // Returns:
 //   - slog.Attr: An attribute with the key "error" and the error's message as the value.
 func Error(err error) slog.Attr {
+	var lv slog.LogValuer
+	if errors.As(err, &lv) {
+		if v := slog.AnyValue(lv).Resolve(); v.Kind() == slog.KindGroup {
+			attrs := []slog.Attr{slog.String("msg", err.Error())}
+			for _, attr := range v.Group() {
+				if attr.Key != "msg" {
+					attrs = append(attrs, attr)
+				}
+			}
+			return slog.Attr{Key: "error", Value: slog.GroupValue(attrs...)}
+		}
+	}
 	return slog.String("error", err.Error())
 }
 
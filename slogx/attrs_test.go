@@ -2,6 +2,7 @@ package slogx
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"testing"
 
@@ -24,6 +25,32 @@ func TestError(t *testing.T) {
 	assert.Equal(t, slog.StringValue("test error"), attr.Value)
 }
 
+type groupError struct{}
+
+func (groupError) Error() string { return "group error" }
+
+func (groupError) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("msg", "group error"), slog.String("source", "main.go:10"))
+}
+
+type stringError struct{}
+
+func (stringError) Error() string { return "string error" }
+
+func (stringError) LogValue() slog.Value { return slog.StringValue("string error") }
+
+func TestErrorLogValuer(t *testing.T) {
+	attr := Error(fmt.Errorf("wrapped: %w", groupError{}))
+	assert.Equal(t, "error", attr.Key)
+	assert.Equal(t, slog.GroupValue(
+		slog.String("msg", "wrapped: group error"),
+		slog.String("source", "main.go:10"),
+	), attr.Value)
+
+	attr = Error(stringError{})
+	assert.Equal(t, slog.StringValue("string error"), attr.Value)
+}
+
 func TestByteString(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,38 @@
+package slogx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextLoggerT struct{}
+
+var contextLogger contextLoggerT
+
+// WithContext returns a copy of ctx that carries the logger, so code that only
+// has the context can log with the attributes of the logger.
+//
+// Parameters:
+//   - ctx: The parent context.
+//   - lg: The logger to store in the context.
+//
+// Returns:
+//   - context.Context: A context carrying the logger.
+func WithContext(ctx context.Context, lg *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextLogger, lg)
+}
+
+// FromContext returns the logger stored in ctx by WithContext, or slog.Default
+// when there is none.
+//
+// Parameters:
+//   - ctx: The context to get the logger from.
+//
+// Returns:
+//   - *slog.Logger: The logger of the context, never nil.
+func FromContext(ctx context.Context) *slog.Logger {
+	if lg, ok := ctx.Value(contextLogger).(*slog.Logger); ok && lg != nil {
+		return lg
+	}
+	return slog.Default()
+}
@@ -0,0 +1,23 @@
+package slogx
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextLogger(t *testing.T) {
+	assert.Same(t, slog.Default(), FromContext(context.Background()))
+	assert.Same(t, slog.Default(), FromContext(WithContext(context.Background(), nil)))
+
+	var logs bytes.Buffer
+	lg := slog.New(slog.NewTextHandler(&logs, nil)).With("request", "abc")
+	ctx := WithContext(context.Background(), lg)
+	assert.Same(t, lg, FromContext(ctx))
+
+	FromContext(ctx).Info("hello")
+	assert.Contains(t, logs.String(), "request=abc")
+}
@@ -1,8 +1,8 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"slices"
 
@@ -12,13 +12,7 @@ import (
 )
 
 func JSONError(w http.ResponseWriter, error string, code int, headers ...http.Header) {
-	for header := range slices.Values(headers) {
-		for k, v := range header {
-			for val := range slices.Values(v) {
-				w.Header().Add(k, val)
-			}
-		}
-	}
+	addHeaders(w, headers)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -30,7 +24,23 @@ func JSONError(w http.ResponseWriter, error string, code int, headers ...http.He
 	fmt.Fprintf(w, `{"message":%q,"code":%d}`, error, code)
 }
 
+// TextError writes a plain text error response, like http.Error but with the
+// JSONError signature so it can be used with RenderMessage.
+func TextError(w http.ResponseWriter, error string, code int, headers ...http.Header) {
+	addHeaders(w, headers)
+	if code <= 0 {
+		code = http.StatusInternalServerError
+	}
+	http.Error(w, error, code)
+}
+
 func JSON[T any](w http.ResponseWriter, data T, code ...int) {
+	JSONContext(context.Background(), w, data, code...)
+}
+
+// JSONContext writes data as a JSON response like JSON, and logs failures with
+// the context logger of ctx, see slogx.FromContext.
+func JSONContext[T any](ctx context.Context, w http.ResponseWriter, data T, code ...int) {
 	status := http.StatusOK
 	if len(code) > 0 {
 		status = code[0]
@@ -40,6 +50,16 @@ func JSON[T any](w http.ResponseWriter, data T, code ...int) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		slog.Error("write json body to response", slogx.Error(err))
+		slogx.FromContext(ctx).Error("write json body to response", slogx.Error(err))
+	}
+}
+
+func addHeaders(w http.ResponseWriter, headers []http.Header) {
+	for header := range slices.Values(headers) {
+		for k, v := range header {
+			for val := range slices.Values(v) {
+				w.Header().Add(k, val)
+			}
+		}
 	}
 }